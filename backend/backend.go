@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Backend - a source of secrets, where each secret is a document of fields at a path
+// Backends are chosen by the scheme of a placeholder's path, e.g. `env:` in
+// `{{ env:HOME!value }}`. Placeholders without a scheme are read from Vault.
+type Backend interface {
+	// ReadDocument - the fields of the document at path, at the given version, or the latest if
+	// version is 0. Backends which make requests stop when ctx is done.
+	ReadDocument(ctx context.Context, path string, version int) (map[string]interface{}, error)
+	// ListDocuments - the names of the documents directly under path
+	ListDocuments(ctx context.Context, path string) ([]string, error)
+	// Capabilities - what the backend supports beyond reading the latest version of a document
+	Capabilities() Capabilities
+}
+
+// Capabilities - optional features of a Backend
+type Capabilities struct {
+	Versions bool // ReadDocument accepts versions other than 0
+	List     bool // ListDocuments is supported
+}
+
+// ErrUnsupported - returned by a backend for an operation its Capabilities don't include
+type ErrUnsupported struct {
+	Scheme    string
+	Operation string
+}
+
+func (e *ErrUnsupported) Error() string {
+	return fmt.Sprintf("the %s backend does not support %s", e.Scheme, e.Operation)
+}
+
+// DefaultScheme - the scheme of placeholders which don't give one
+const DefaultScheme = "vault"
+
+var schemePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]+$`)
+
+// ValidScheme - whether s can be used as a scheme. A single letter is not, so that Windows drive
+// letters aren't mistaken for schemes.
+func ValidScheme(s string) bool {
+	return schemePattern.MatchString(s)
+}
+
+var registry = map[string]Backend{
+	"env":  EnvBackend{},
+	"file": FileBackend{},
+}
+
+// Register - make a backend available under the given scheme, replacing any existing backend
+// with that scheme. Backends should be registered before secrets are resolved.
+func Register(scheme string, b Backend) {
+	if !ValidScheme(scheme) {
+		panic(fmt.Sprintf("backend: invalid scheme %q", scheme))
+	}
+	registry[scheme] = b
+}
+
+// Registered - a copy of the registered backends, keyed by scheme
+func Registered() map[string]Backend {
+	backends := make(map[string]Backend, len(registry))
+	for scheme, b := range registry {
+		backends[scheme] = b
+	}
+	return backends
+}
+
+// Schemes - the sorted schemes of the given backends, for messages
+func Schemes(backends map[string]Backend) []string {
+	schemes := make([]string, 0, len(backends))
+	for scheme := range backends {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type nullBackend struct{}
+
+func (nullBackend) ReadDocument(ctx context.Context, path string, version int) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+func (nullBackend) ListDocuments(ctx context.Context, path string) ([]string, error) {
+	return nil, nil
+}
+
+func (nullBackend) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+func TestValidScheme(t *testing.T) {
+	for scheme, valid := range map[string]bool{
+		"vault":     true,
+		"env":       true,
+		"s3+https":  true,
+		"c":         false,
+		"":          false,
+		"1password": false,
+		"my scheme": false,
+	} {
+		assert.Equal(t, valid, ValidScheme(scheme), scheme)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("null", nullBackend{})
+	defer delete(registry, "null")
+
+	backends := Registered()
+	assert.Contains(t, backends, "null")
+
+	// Registered returns a copy
+	delete(backends, "null")
+	assert.Contains(t, Registered(), "null")
+}
+
+func TestRegister_InvalidScheme(t *testing.T) {
+	assert.Panics(t, func() { Register("a b", nullBackend{}) })
+}
+
+func TestSchemes(t *testing.T) {
+	assert.Equal(t, []string{"a", "b"}, Schemes(map[string]Backend{"b": nullBackend{}, "a": nullBackend{}}))
+}
@@ -0,0 +1,280 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// KeyEnvVar - the environment variable a secrets file key is read from, if no key file is given
+const KeyEnvVar = "TALEBEARER_SECRETS_KEY"
+
+// keySize - secrets files are encrypted with AES-256
+const keySize = 32
+
+// secretsFileVersion - the version of the secrets file format, which is also authenticated as
+// additional data
+const secretsFileVersion = 1
+
+// SecretsFile - the decrypted contents of a secrets file: documents of fields, keyed by path
+// like Vault, e.g. `secret/example` → `{foo: bar}`
+type SecretsFile map[string]map[string]interface{}
+
+// encryptedFile - a secrets file as it is stored on disk
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	Cipher     string `json:"cipher"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// GenerateKey - generate a new random key for a secrets file
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncodeKey - encode a key as it is written in a key file or KeyEnvVar
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// LoadKey - load a secrets file key from keyFile, or from KeyEnvVar if keyFile is empty
+func LoadKey(keyFile string) ([]byte, error) {
+	var encoded string
+	source := KeyEnvVar
+	if keyFile != "" {
+		contents, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading key: %s", err)
+		}
+		encoded = string(contents)
+		source = keyFile
+	} else {
+		var ok bool
+		if encoded, ok = os.LookupEnv(KeyEnvVar); !ok {
+			return nil, fmt.Errorf("no key file was given and %s is not set", KeyEnvVar)
+		}
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("key in %s is not valid base64: %s", source, err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key in %s is %d bytes, expected %d", source, len(key), keySize)
+	}
+	return key, nil
+}
+
+// EncryptSecrets - encrypt the contents of a secrets file with key
+func EncryptSecrets(key []byte, secrets SecretsFile) ([]byte, error) {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(encryptedFile{
+		Version:    secretsFileVersion,
+		Cipher:     "aes-256-gcm",
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, additionalData())),
+	}, "", "  ")
+}
+
+// DecryptSecrets - decrypt the contents of a secrets file with key
+func DecryptSecrets(key []byte, data []byte) (SecretsFile, error) {
+	var f encryptedFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("not a secrets file: %s", err)
+	}
+	if f.Version != secretsFileVersion || f.Cipher != "aes-256-gcm" {
+		return nil, fmt.Errorf("unsupported secrets file version %d with cipher %q", f.Version, f.Cipher)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(f.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %s", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(f.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %s", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce: %d bytes, expected %d", len(nonce), gcm.NonceSize())
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData())
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting, the key is probably wrong")
+	}
+
+	return ParseSecrets(plaintext)
+}
+
+// ParseSecrets - parse the plaintext JSON form of a secrets file, an object of objects
+func ParseSecrets(plaintext []byte) (SecretsFile, error) {
+	dec := json.NewDecoder(bytes.NewReader(plaintext))
+	dec.UseNumber()
+	var raw map[string]interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("secrets must be a JSON object of paths to objects: %s", err)
+	}
+
+	secrets := make(SecretsFile, len(raw))
+	for path, doc := range raw {
+		fields, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("secrets at %s must be a JSON object, not %T", path, doc)
+		}
+		secrets[sanitisePath(path)] = fields
+	}
+	return secrets, nil
+}
+
+// ReadSecretsFile - read and decrypt the secrets file at path
+func ReadSecretsFile(path string, key []byte) (SecretsFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := DecryptSecrets(key, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading secrets file %s: %s", path, err)
+	}
+	return secrets, nil
+}
+
+// WriteSecretsFile - encrypt secrets and write them to the file at path, replacing it atomically
+func WriteSecretsFile(path string, key []byte, secrets SecretsFile) error {
+	data, err := EncryptSecrets(key, secrets)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, append(data, '\n'), 0600)
+}
+
+// WriteFileAtomic - write data to a temporary file which then replaces the file at path, so
+// the file is never partly written
+func WriteFileAtomic(path string, data []byte, mode os.FileMode) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	return err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func additionalData() []byte {
+	return []byte(fmt.Sprintf("talebearer secrets file v%d", secretsFileVersion))
+}
+
+// sanitisePath - trim whitespace and leading/trailing slashes, as Vault paths are
+func sanitisePath(path string) string {
+	return strings.Trim(strings.TrimSpace(path), "/")
+}
+
+// EncryptedFileBackend - reads secrets from an encrypted secrets file, with the same paths
+// and keys as Vault, for rendering templates without access to Vault
+type EncryptedFileBackend struct {
+	path    string
+	secrets SecretsFile
+}
+
+// NewEncryptedFileBackend - decrypt the secrets file at path with key
+func NewEncryptedFileBackend(path string, key []byte) (*EncryptedFileBackend, error) {
+	secrets, err := ReadSecretsFile(path, key)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedFileBackend{path: path, secrets: secrets}, nil
+}
+
+// ReadDocument - the fields of the document at path
+func (b *EncryptedFileBackend) ReadDocument(ctx context.Context, path string, version int) (map[string]interface{}, error) {
+	doc, ok := b.secrets[sanitisePath(path)]
+	if !ok {
+		return nil, fmt.Errorf("secrets file %s has no secret at %s", b.path, path)
+	}
+	return doc, nil
+}
+
+// ListDocuments - the sorted names of the documents directly under path, with those which
+// have documents under them suffixed by `/` as Vault does
+func (b *EncryptedFileBackend) ListDocuments(ctx context.Context, path string) ([]string, error) {
+	prefix := sanitisePath(path)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for p := range b.secrets {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(p, prefix)
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i+1]
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Capabilities - documents can be listed, but have no versions
+func (b *EncryptedFileBackend) Capabilities() Capabilities {
+	return Capabilities{List: true}
+}
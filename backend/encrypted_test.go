@@ -0,0 +1,112 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testSecrets = SecretsFile{
+	"secret/example":    {"foo": "bar", "port": json.Number("8080")},
+	"secret/team/db":    {"password": "hunter2"},
+	"secret/team/cache": {"password": "s3cret"},
+}
+
+func TestEncryptSecrets_RoundTrip(t *testing.T) {
+	key, err := GenerateKey()
+	assert.NoError(t, err)
+
+	data, err := EncryptSecrets(key, testSecrets)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+
+	secrets, err := DecryptSecrets(key, data)
+	assert.NoError(t, err)
+	assert.Equal(t, testSecrets, secrets)
+
+	otherKey, _ := GenerateKey()
+	_, err = DecryptSecrets(otherKey, data)
+	assert.EqualError(t, err, "failed decrypting, the key is probably wrong")
+}
+
+func TestDecryptSecrets_Tampered(t *testing.T) {
+	key, _ := GenerateKey()
+	data, _ := EncryptSecrets(key, testSecrets)
+
+	var f encryptedFile
+	assert.NoError(t, json.Unmarshal(data, &f))
+	f.Version = 2
+	tampered, _ := json.Marshal(f)
+	_, err := DecryptSecrets(key, tampered)
+	assert.Contains(t, err.Error(), "unsupported secrets file version 2")
+
+	_, err = DecryptSecrets(key, []byte("secret/example: {}"))
+	assert.Contains(t, err.Error(), "not a secrets file")
+}
+
+func TestParseSecrets(t *testing.T) {
+	secrets, err := ParseSecrets([]byte(`{"/secret/example/": {"foo": "bar"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, SecretsFile{"secret/example": {"foo": "bar"}}, secrets)
+
+	_, err = ParseSecrets([]byte(`{"secret/example": "bar"}`))
+	assert.EqualError(t, err, "secrets at secret/example must be a JSON object, not string")
+}
+
+func TestLoadKey(t *testing.T) {
+	key, _ := GenerateKey()
+	dir := t.TempDir()
+
+	keyFile := filepath.Join(dir, "key")
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte(EncodeKey(key)+"\n"), 0600))
+	loaded, err := LoadKey(keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	os.Setenv(KeyEnvVar, EncodeKey(key))
+	loaded, err = LoadKey("")
+	assert.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	os.Setenv(KeyEnvVar, EncodeKey(key[:16]))
+	_, err = LoadKey("")
+	assert.EqualError(t, err, "key in "+KeyEnvVar+" is 16 bytes, expected 32")
+
+	os.Unsetenv(KeyEnvVar)
+	_, err = LoadKey("")
+	assert.EqualError(t, err, "no key file was given and "+KeyEnvVar+" is not set")
+}
+
+func TestEncryptedFileBackend(t *testing.T) {
+	key, _ := GenerateKey()
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "dev.secrets")
+	assert.NoError(t, WriteSecretsFile(file, key, testSecrets))
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	b, err := NewEncryptedFileBackend(file, key)
+	assert.NoError(t, err)
+
+	doc, err := b.ReadDocument(context.Background(), "/secret/example", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", doc["foo"])
+
+	_, err = b.ReadDocument(context.Background(), "secret/missing", 0)
+	assert.True(t, strings.HasSuffix(err.Error(), "has no secret at secret/missing"))
+
+	names, err := b.ListDocuments(context.Background(), "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example", "team/"}, names)
+	names, err = b.ListDocuments(context.Background(), "secret/team/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cache", "db"}, names)
+}
@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ValueKey - the key read by placeholders which give a scheme but no key, e.g. `{{ env:HOME }}`,
+// which backends use for documents that are a single value
+const ValueKey = "value"
+
+// EnvBackend - reads secrets from environment variables, e.g. `{{ env:DB_PASSWORD }}`
+// Each variable is a document with a single field, ValueKey.
+type EnvBackend struct{}
+
+// ReadDocument - the value of the environment variable named by path, which must be set
+func (EnvBackend) ReadDocument(ctx context.Context, path string, version int) (map[string]interface{}, error) {
+	value, ok := os.LookupEnv(path)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", path)
+	}
+	return map[string]interface{}{ValueKey: value}, nil
+}
+
+// ListDocuments - the sorted names of the environment variables starting with path
+func (EnvBackend) ListDocuments(ctx context.Context, path string) ([]string, error) {
+	var names []string
+	for _, env := range os.Environ() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if strings.HasPrefix(name, path) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Capabilities - environment variables can be listed, but have no versions
+func (EnvBackend) Capabilities() Capabilities {
+	return Capabilities{List: true}
+}
@@ -0,0 +1,32 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvBackend_ReadDocument(t *testing.T) {
+	os.Setenv("TALEBEARER_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("TALEBEARER_TEST_SECRET")
+
+	doc, err := EnvBackend{}.ReadDocument(context.Background(), "TALEBEARER_TEST_SECRET", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"value": "s3cret"}, doc)
+
+	_, err = EnvBackend{}.ReadDocument(context.Background(), "TALEBEARER_TEST_UNSET", 0)
+	assert.EqualError(t, err, "environment variable TALEBEARER_TEST_UNSET is not set")
+}
+
+func TestEnvBackend_ListDocuments(t *testing.T) {
+	os.Setenv("TALEBEARER_TEST_B", "")
+	os.Setenv("TALEBEARER_TEST_A", "")
+	defer os.Unsetenv("TALEBEARER_TEST_A")
+	defer os.Unsetenv("TALEBEARER_TEST_B")
+
+	names, err := EnvBackend{}.ListDocuments(context.Background(), "TALEBEARER_TEST_")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"TALEBEARER_TEST_A", "TALEBEARER_TEST_B"}, names)
+}
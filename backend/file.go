@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileBackend - reads secrets from local files, e.g. `{{ file:/run/secrets/db!password }}`
+// A file ending in .json is a document of the fields of its top-level object, and one ending in
+// .properties a document of its properties. Any other file is a document with a single field,
+// ValueKey, holding the file's contents exactly as they are.
+type FileBackend struct{}
+
+// ReadDocument - read the fields of the file at path
+func (FileBackend) ReadDocument(ctx context.Context, path string, version int) (map[string]interface{}, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return jsonDocument(path, contents)
+	case ".properties":
+		fields := make(map[string]interface{})
+		for key, value := range ParseProperties(string(contents)) {
+			fields[key] = value
+		}
+		return fields, nil
+	}
+	return map[string]interface{}{ValueKey: string(contents)}, nil
+}
+
+// jsonDocument - the fields of a JSON object, or a document with the value of any other JSON
+// document as its ValueKey
+func jsonDocument(path string, contents []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(contents))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("could not parse %s as JSON: %s", path, err)
+	}
+	if fields, ok := v.(map[string]interface{}); ok {
+		return fields, nil
+	}
+	return map[string]interface{}{ValueKey: v}, nil
+}
+
+// ListDocuments - the sorted names of the files in the directory at path, with directories
+// suffixed by `/` as Vault does
+func (FileBackend) ListDocuments(ctx context.Context, path string) ([]string, error) {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Capabilities - files can be listed, but have no versions
+func (FileBackend) Capabilities() Capabilities {
+	return Capabilities{List: true}
+}
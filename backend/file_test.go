@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestFileBackend_ReadDocument(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"password":        "hunter2\n",
+		"db.json":         `{"user": "app", "port": 5432, "tags": ["a"]}`,
+		"list.json":       `["a", "b"]`,
+		"app.properties":  "# comment\nuser = app\npassword=p\\=ss\n",
+		"broken.json":     `{"user": `,
+		"nested/key.pem":  "-----BEGIN-----",
+		"UPPER.JSON":      `{"a": "b"}`,
+		"not-json.jsonld": `{"a": "b"}`,
+	})
+
+	var tests = []struct {
+		name     string
+		expected map[string]interface{}
+	}{
+		{"password", map[string]interface{}{"value": "hunter2\n"}},
+		{"db.json", map[string]interface{}{
+			"user": "app", "port": json.Number("5432"), "tags": []interface{}{"a"},
+		}},
+		{"list.json", map[string]interface{}{"value": []interface{}{"a", "b"}}},
+		{"app.properties", map[string]interface{}{"user": "app", "password": "p=ss"}},
+		{"nested/key.pem", map[string]interface{}{"value": "-----BEGIN-----"}},
+		{"UPPER.JSON", map[string]interface{}{"a": "b"}},
+		{"not-json.jsonld", map[string]interface{}{"value": `{"a": "b"}`}},
+	}
+	for _, tc := range tests {
+		doc, err := FileBackend{}.ReadDocument(context.Background(), filepath.Join(dir, tc.name), 0)
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expected, doc, tc.name)
+	}
+
+	_, err := FileBackend{}.ReadDocument(context.Background(), filepath.Join(dir, "broken.json"), 0)
+	assert.Contains(t, err.Error(), "could not parse")
+	_, err = FileBackend{}.ReadDocument(context.Background(), filepath.Join(dir, "missing"), 0)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFileBackend_ListDocuments(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"b":          "",
+		"a":          "",
+		"nested/key": "",
+	})
+
+	names, err := FileBackend{}.ListDocuments(context.Background(), dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "nested/"}, names)
+}
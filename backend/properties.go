@@ -0,0 +1,130 @@
+package backend
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseProperties - parse the contents of a Java properties file, as java.util.Properties does
+// Later keys replace earlier ones, and malformed `\u` escapes are kept as they are.
+func ParseProperties(contents string) map[string]string {
+	properties := make(map[string]string)
+	for _, line := range propertiesLines(contents) {
+		key, value := splitProperty(line)
+		properties[unescapeProperty(key)] = unescapeProperty(value)
+	}
+	return properties
+}
+
+// propertiesLines - the logical lines of a properties file, with continuation lines joined and
+// blank lines and comments removed
+func propertiesLines(contents string) []string {
+	contents = strings.Replace(contents, "\r\n", "\n", -1)
+	contents = strings.Replace(contents, "\r", "\n", -1)
+
+	var lines []string
+	var logical strings.Builder
+	continuing := false
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimLeft(line, " \t\f")
+		if !continuing && (line == "" || line[0] == '#' || line[0] == '!') {
+			continue
+		}
+
+		continuing = trailingBackslashes(line)%2 == 1
+		if continuing {
+			line = line[:len(line)-1]
+		}
+		logical.WriteString(line)
+		if !continuing {
+			lines = append(lines, logical.String())
+			logical.Reset()
+		}
+	}
+	if logical.Len() > 0 {
+		lines = append(lines, logical.String())
+	}
+	return lines
+}
+
+func trailingBackslashes(line string) int {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n
+}
+
+// splitProperty - split a logical line into its (still escaped) key and value
+// The key ends at the first unescaped `=`, `:` or whitespace, and the separator may be
+// surrounded by whitespace.
+func splitProperty(line string) (key string, value string) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte("=: \t\f", line[i]) >= 0 {
+			end = i
+			break
+		}
+	}
+
+	rest := strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	return line[:end], rest
+}
+
+// unescapeProperty - resolve the escapes in a key or value
+func unescapeProperty(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 <= len(s) {
+				if r, err := strconv.ParseUint(s[i+1:i+5], 16, 16); err == nil {
+					b.WriteString(decodeUTF16Escapes(s, &i, rune(r)))
+					continue
+				}
+			}
+			b.WriteString(`\u`)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// decodeUTF16Escapes - the character for a `\uXXXX` escape with value r, where s[*i] is the `u`,
+// combining it with a following low surrogate escape if r is a high surrogate. Advances *i past
+// the escapes used.
+func decodeUTF16Escapes(s string, i *int, r rune) string {
+	*i += 4
+	if r >= 0xD800 && r < 0xDC00 && *i+7 <= len(s) && s[*i+1:*i+3] == `\u` {
+		if low, err := strconv.ParseUint(s[*i+3:*i+7], 16, 16); err == nil && low >= 0xDC00 && low < 0xE000 {
+			*i += 6
+			return string((r-0xD800)<<10 + (rune(low) - 0xDC00) + 0x10000)
+		}
+	}
+	return string(r)
+}
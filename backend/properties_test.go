@@ -0,0 +1,41 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProperties(t *testing.T) {
+	contents := "# A comment\n" +
+		"! Another comment\n" +
+		"\n" +
+		"a=1\n" +
+		"  b : 2\n" +
+		"c 3\n" +
+		"d\n" +
+		"e=\n" +
+		"f=multi \\\n" +
+		"    line\n" +
+		"key\\ with\\:separators = value\\twith\\nescapes\n" +
+		"unicode=caf\\u00e9 \\ud83d\\ude00\n" +
+		"bad=\\uzzzz\n" +
+		"backslash=ends\\\\\n" +
+		"a=replaced\r\n" +
+		"last=no newline\\"
+
+	expected := map[string]string{
+		"a":                   "replaced",
+		"b":                   "2",
+		"c":                   "3",
+		"d":                   "",
+		"e":                   "",
+		"f":                   "multi line",
+		"key with:separators": "value\twith\nescapes",
+		"unicode":             "café 😀",
+		"bad":                 `\uzzzz`,
+		"backslash":           `ends\`,
+		"last":                "no newline",
+	}
+	assert.Equal(t, expected, ParseProperties(contents))
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/al4/talebearer/vault"
+)
+
+// subcommand - a command run as `talebearer <name> [args...]`, instead of rendering a template
+type subcommand struct {
+	summary string
+	run     func(args []string) error
+}
+
+var subcommands = map[string]subcommand{
+	"policy": {
+		summary: "Generate a read-only Vault policy for the secrets templates read",
+		run:     runPolicyCommand,
+	},
+	"secrets": {
+		summary: "Create, edit or re-key an encrypted secrets file",
+		run:     runSecretsCommand,
+	},
+	"seed": {
+		summary: "Write secrets into Vault from a YAML, JSON or properties file",
+		run:     runSeedCommand,
+	},
+}
+
+// subcommandArgs - the arguments after the global flags if they start with the name of a
+// subcommand, otherwise nil
+var subcommandArgs []string
+
+// parseArgs - parse the global flags from args, returning the remaining args if they start with
+// the name of a subcommand, so that global flags such as -log-level can be given before it
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if _, ok := subcommands[fs.Arg(0)]; ok {
+		return fs.Args(), nil
+	}
+	return nil, nil
+}
+
+// logLevelFlag - add -log-level to a subcommand's flags, defaulting to the level given before the
+// subcommand name. The returned function applies it once the flags are parsed.
+func logLevelFlag(fs *flag.FlagSet) func() error {
+	level := fs.String("log-level", logLevel, fmt.Sprintf("Log level, valid values are %+v",
+		log.AllLevels))
+	return func() error {
+		return setLogLevel(*level)
+	}
+}
+
+// vaultFlags - how to connect and log in to Vault, given by the same flags to every command which
+// reads or writes Vault
+type vaultFlags struct {
+	role          string
+	authMethod    string
+	authMount     string
+	authParams    keyValueFlag
+	agentSinkFile string
+	tokenHelper   string
+	tlsCACert     string
+	tlsClientCert string
+	tlsClientKey  string
+	tlsServerName string
+	tlsMinVersion string
+	mountVersions string
+}
+
+// addVaultFlags - add the flags of how to connect and log in to Vault to fs
+func addVaultFlags(fs *flag.FlagSet) *vaultFlags {
+	f := &vaultFlags{authParams: keyValueFlag{}}
+	fs.StringVar(
+		&f.role, "role", "", "The Vault role to authenticate as",
+	)
+	fs.StringVar(
+		&f.authMethod, "auth-method", vault.DefaultAuthMethod, fmt.Sprintf("The Vault auth method "+
+			"to log in with, one of %v, unless a token is already set", vault.AuthMethods()),
+	)
+	fs.StringVar(
+		&f.authMount, "auth-mount", "", "The path the auth method is mounted at, by default the "+
+			"name of the method",
+	)
+	fs.Var(
+		&f.authParams, "auth-param", "A parameter of the auth method as key=value, e.g. "+
+			"role_id_file=/run/role-id. May be given more than once",
+	)
+	fs.StringVar(
+		&f.agentSinkFile, "agent-sink-file", "", "Use the token a Vault Agent writes to this file "+
+			"sink rather than logging in, re-reading it whenever the agent renews it",
+	)
+	fs.StringVar(
+		&f.tokenHelper, "token-helper", "", "Use the token printed by this token helper "+
+			"executable, run with the argument get, rather than logging in",
+	)
+	fs.StringVar(
+		&f.tlsCACert, "tls-ca-cert", "", "A PEM bundle of the CAs to trust when connecting to "+
+			"Vault, instead of the system's. Overrides VAULT_CACERT",
+	)
+	fs.StringVar(
+		&f.tlsClientCert, "tls-client-cert", "", "A PEM client certificate to present to Vault, "+
+			"e.g. to log in with the cert auth method. Overrides VAULT_CLIENT_CERT",
+	)
+	fs.StringVar(
+		&f.tlsClientKey, "tls-client-key", "", "The private key of -tls-client-cert. Overrides "+
+			"VAULT_CLIENT_KEY",
+	)
+	fs.StringVar(
+		&f.tlsServerName, "tls-server-name", "", "The name to verify Vault's certificate against, "+
+			"if not the host of VAULT_ADDR. Overrides VAULT_TLS_SERVER_NAME",
+	)
+	fs.StringVar(
+		&f.tlsMinVersion, "tls-min-version", "", "The minimum TLS version to connect to Vault "+
+			"with, one of 1.0, 1.1, 1.2 or 1.3. Defaults to 1.2",
+	)
+	fs.StringVar(
+		&f.mountVersions, "mount-versions", "", "The KV API versions of mounts, e.g. "+
+			"secret=2,legacy=1. Otherwise each mount's version is looked up once from "+
+			"sys/internal/ui/mounts, which the token may not be allowed to read",
+	)
+	return f
+}
+
+// authParamsWithMount - the -auth-param flags, and the mount given by -auth-mount
+func (f *vaultFlags) authParamsWithMount() map[string]string {
+	params := map[string]string{}
+	for k, v := range f.authParams {
+		params[k] = v
+	}
+	if f.authMount != "" {
+		params["mount"] = f.authMount
+	}
+	return params
+}
+
+// tls - the TLS flags, which override the VAULT_CACERT etc. environment variables
+func (f *vaultFlags) tls() (vault.TLSConfig, error) {
+	if f.authMethod == "cert" && f.tlsClientCert == "" && os.Getenv("VAULT_CLIENT_CERT") == "" {
+		return vault.TLSConfig{}, fmt.Errorf("the cert auth method needs a client certificate, " +
+			"given with -tls-client-cert and -tls-client-key")
+	}
+	minVersion, err := vault.ParseTLSVersion(f.tlsMinVersion)
+	if err != nil {
+		return vault.TLSConfig{}, fmt.Errorf("invalid -tls-min-version: %s", err)
+	}
+	return vault.TLSConfig{
+		CACert:     f.tlsCACert,
+		ClientCert: f.tlsClientCert,
+		ClientKey:  f.tlsClientKey,
+		ServerName: f.tlsServerName,
+		MinVersion: minVersion,
+	}, nil
+}
+
+// versions - the KV API versions of mounts given by -mount-versions
+func (f *vaultFlags) versions() (map[string]int, error) {
+	versions, err := vault.ParseMountVersions(f.mountVersions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -mount-versions: %s", err)
+	}
+	return versions, nil
+}
+
+// options - the options of a Vault client which connects and logs in as the flags say
+func (f *vaultFlags) options() ([]vault.Option, error) {
+	tls, err := f.tls()
+	if err != nil {
+		return nil, err
+	}
+	versions, err := f.versions()
+	if err != nil {
+		return nil, err
+	}
+	opts := []vault.Option{
+		vault.WithAuthMethod(f.authMethod, f.authParamsWithMount()),
+		vault.WithTLS(tls),
+	}
+	if len(versions) > 0 {
+		opts = append(opts, vault.WithMountVersions(versions))
+	}
+	if f.agentSinkFile != "" {
+		opts = append(opts, vault.WithAgentSink(f.agentSinkFile))
+	}
+	if f.tokenHelper != "" {
+		opts = append(opts, vault.WithTokenHelper(f.tokenHelper))
+	}
+	return opts, nil
+}
+
+// setLogLevel - set the level of logging by name
+func setLogLevel(level string) error {
+	ll, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(ll)
+	return nil
+}
+
+// runSubcommand - run the subcommand named by args[0] with the remaining args
+func runSubcommand(args []string) error {
+	cmd, ok := subcommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return cmd.run(args[1:])
+}
+
+// printSubcommands - list the subcommands, for usage messages
+func printSubcommands() {
+	var names []string
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println("\nCommands, which take -log-level before or after the command name:")
+	for _, name := range names {
+		fmt.Printf("  talebearer %s\n    \t%s\n", name, subcommands[name].summary)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseArgs_GlobalFlagsBeforeSubcommand(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	level := fs.String("log-level", "info", "")
+
+	args, err := parseArgs(fs, []string{"-log-level", "debug", "secrets", "edit", "-file", "f"})
+	assert.NoError(t, err)
+	assert.Equal(t, "debug", *level)
+	assert.Equal(t, []string{"secrets", "edit", "-file", "f"}, args)
+
+	args, err = parseArgs(fs, []string{"-log-level", "warn"})
+	assert.NoError(t, err)
+	assert.Nil(t, args)
+}
+
+func TestLogLevelFlag(t *testing.T) {
+	defer log.SetLevel(log.GetLevel())
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	apply := logLevelFlag(fs)
+	assert.NoError(t, fs.Parse([]string{"-log-level", "debug"}))
+	assert.NoError(t, apply())
+	assert.Equal(t, log.DebugLevel, log.GetLevel())
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	apply = logLevelFlag(fs)
+	assert.NoError(t, fs.Parse([]string{"-log-level", "loud"}))
+	assert.Error(t, apply())
+}
+
+func TestAddVaultFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	conn := addVaultFlags(fs)
+	assert.NoError(t, fs.Parse([]string{
+		"-role", "app", "-auth-method", "approle", "-auth-mount", "ci", "-auth-param", "role_id=r",
+		"-tls-min-version", "1.3", "-mount-versions", "secret=2", "-agent-sink-file", "/run/sink",
+	}))
+	assert.Equal(t, "app", conn.role)
+	assert.Equal(t, map[string]string{"role_id": "r", "mount": "ci"}, conn.authParamsWithMount())
+	tlsConfig, err := conn.tls()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+	opts, err := conn.options()
+	assert.NoError(t, err)
+	assert.Len(t, opts, 4)
+
+	// Each flag set has its own values
+	other := addVaultFlags(flag.NewFlagSet("other", flag.ContinueOnError))
+	assert.Empty(t, other.role)
+	assert.Empty(t, other.authParamsWithMount())
+
+	assert.NoError(t, fs.Parse([]string{"-auth-method", "cert"}))
+	_, err = conn.options()
+	assert.EqualError(t, err, "the cert auth method needs a client certificate, given with "+
+		"-tls-client-cert and -tls-client-key")
+}
@@ -1,4 +1,4 @@
 #!/bin/bash
 
-vault write secret/example foo=bar
-vault write secret/example two=2222
+# Write the examples' secrets into Vault, e.g. a dev server, merging them into any which exist
+talebearer seed -file "$(dirname "$0")/seed.yaml" -merge "$@"
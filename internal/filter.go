@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Filter - transforms a secret value, given the arguments written after the filter's name in the
+// placeholder, e.g. `{{ secret/example!key | indent 4 }}`
+type Filter func(value string, args []string) (string, error)
+
+var filters = map[string]Filter{
+	"base64":       noArgs(func(v string) (string, error) { return base64.StdEncoding.EncodeToString([]byte(v)), nil }),
+	"base64decode": noArgs(base64Decode),
+	"bcrypt":       bcryptFilter,
+	"indent":       indentFilter,
+	"json":         noArgs(jsonFilter),
+	"lower":        noArgs(func(v string) (string, error) { return strings.ToLower(v), nil }),
+	"sha256":       noArgs(sha256Filter),
+	"trim":         noArgs(func(v string) (string, error) { return strings.TrimSpace(v), nil }),
+	"upper":        noArgs(func(v string) (string, error) { return strings.ToUpper(v), nil }),
+	"urlencode":    noArgs(func(v string) (string, error) { return url.QueryEscape(v), nil }),
+}
+
+// RegisterFilter - make a filter available to placeholders under the given name, replacing any
+// existing filter of that name. Filters should be registered before templates are parsed.
+func RegisterFilter(name string, f Filter) {
+	filters[name] = f
+}
+
+// FilteredSecret - a Secret with filters to apply to its value once it has been retrieved
+type FilteredSecret interface {
+	Secret
+	ApplyFilters() error
+}
+
+// ApplyFilters - apply the filters of each secret to its value
+func ApplyFilters(secrets map[string]Secret) error {
+	var errStrings []string
+	for p, s := range secrets {
+		fs, ok := s.(FilteredSecret)
+		if !ok {
+			continue
+		}
+		if err := fs.ApplyFilters(); err != nil {
+			errStrings = append(errStrings, fmt.Sprintf("\"%s: %s\"", p, err.Error()))
+		}
+	}
+
+	if len(errStrings) > 0 {
+		sort.Strings(errStrings)
+		return fmt.Errorf("[%s]", strings.Join(errStrings, ", "))
+	}
+	return nil
+}
+
+// applyFilters - apply a pipeline of filters to a value
+func applyFilters(value string, modifiers []Modifier) (string, error) {
+	for _, m := range modifiers {
+		f, ok := filters[m.Name]
+		if !ok {
+			return "", fmt.Errorf("unknown filter %q", m.Name)
+		}
+		var err error
+		value, err = f(value, m.Args)
+		if err != nil {
+			return "", fmt.Errorf("filter %s: %s", m.Name, err)
+		}
+	}
+	return value, nil
+}
+
+func noArgs(f func(string) (string, error)) Filter {
+	return func(value string, args []string) (string, error) {
+		if len(args) > 0 {
+			return "", fmt.Errorf("takes no arguments, got %d", len(args))
+		}
+		return f(value)
+	}
+}
+
+func base64Decode(value string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		// Also accept unpadded input
+		var rawErr error
+		decoded, rawErr = base64.RawStdEncoding.DecodeString(value)
+		if rawErr != nil {
+			return "", err
+		}
+	}
+	return string(decoded), nil
+}
+
+func sha256Filter(value string) (string, error) {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// jsonFilter - encode the value as a quoted JSON string
+func jsonFilter(value string) (string, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// indentFilter - indent every line but the first by the given number of spaces, as the first line
+// is rendered wherever the placeholder is
+func indentFilter(value string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("takes one argument, the number of spaces")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("invalid number of spaces %q", args[0])
+	}
+	return strings.Replace(value, "\n", "\n"+strings.Repeat(" ", n), -1), nil
+}
+
+// bcryptFilter - hash the value with bcrypt, at the default cost unless one is given
+func bcryptFilter(value string, args []string) (string, error) {
+	cost := bcrypt.DefaultCost
+	switch len(args) {
+	case 0:
+	case 1:
+		var err error
+		cost, err = strconv.Atoi(args[0])
+		if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return "", fmt.Errorf("invalid cost %q, must be between %d and %d", args[0], bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return "", fmt.Errorf("takes at most one argument, the cost")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(value), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
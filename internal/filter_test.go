@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var filterTests = []struct {
+	placeholder string
+	value       string
+	expected    string
+}{
+	{"secret/a!b | base64", "hello", "aGVsbG8="},
+	{"secret/a!b | base64decode", "aGVsbG8=", "hello"},
+	{"secret/a!b | base64decode", "aGVsbG8", "hello"},
+	{"secret/a!b | sha256", "hello", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+	{"secret/a!b | trim", "  hello\n", "hello"},
+	{"secret/a!b | upper", "Hello", "HELLO"},
+	{"secret/a!b | lower", "Hello", "hello"},
+	{"secret/a!b | indent 2", "a\nb\nc", "a\n  b\n  c"},
+	{"secret/a!b | urlencode", "p@ss w/rd&", "p%40ss+w%2Frd%26"},
+	{"secret/a!b | json", "say \"<hi>\"", `"say \"<hi>\""`},
+	{"secret/a!b | base64decode | trim | upper", "IGhlbGxvCg==", "HELLO"},
+}
+
+func TestApplyFilters(t *testing.T) {
+	for _, tc := range filterTests {
+		s, err := NewSecret(tc.placeholder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetValue(tc.value)
+
+		err = ApplyFilters(map[string]Secret{tc.placeholder: s})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.placeholder, err)
+			continue
+		}
+		if s.Value() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.placeholder, tc.expected, s.Value())
+		}
+	}
+}
+
+func TestApplyFilters_Bcrypt(t *testing.T) {
+	s, err := NewSecret("secret/a!b:password | bcrypt 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ApplyFilters(map[string]Secret{"p": s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(s.Value()), []byte("password")) != nil {
+		t.Errorf("%s is not a bcrypt hash of the fallback value", s.Value())
+	}
+	if cost, _ := bcrypt.Cost([]byte(s.Value())); cost != 4 {
+		t.Errorf("expected cost 4, got %d", cost)
+	}
+}
+
+func TestApplyFilters_Errors(t *testing.T) {
+	secrets := make(map[string]Secret)
+	for _, p := range []string{
+		"secret/a!b | indent",
+		"secret/a!b | indent x",
+		"secret/a!b | upper 1",
+		"secret/a!b | base64decode",
+		"secret/a!b | bcrypt 100",
+	} {
+		secrets[p], _ = NewSecret(p)
+		secrets[p].SetValue("not base64!")
+	}
+
+	err := ApplyFilters(secrets)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{
+		"secret/a!b | indent: filter indent: takes one argument",
+		"secret/a!b | indent x: filter indent: invalid number of spaces",
+		"secret/a!b | upper 1: filter upper: takes no arguments",
+		"secret/a!b | base64decode: filter base64decode: illegal base64 data",
+		"secret/a!b | bcrypt 100: filter bcrypt: invalid cost",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain '%s', got '%s'", expected, err)
+		}
+	}
+}
+
+func TestApplyFilters_FailureNeverRendersUnfilteredValue(t *testing.T) {
+	secrets := make(map[string]Secret)
+	secrets["{{ secret/a!b | base64decode | sha256 }}"], _ = NewSecret("secret/a!b | base64decode | sha256")
+	secrets["{{ secret/a!b | base64decode | sha256 }}"].SetValue("hunter2!")
+	wildcard, _ := NewSecret("{{ secret/c!* | base64decode }}")
+	wildcard.(*WildcardSecret).fields = map[string]string{"ok": "aGk=", "bad": "hunter2!"}
+	secrets["{{ secret/c!* | base64decode }}"] = wildcard
+
+	if err := ApplyFilters(secrets); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	template := "a={{ secret/a!b | base64decode | sha256 }}\n{{ secret/c!* | base64decode }}\n"
+	var out bytes.Buffer
+	if err := Render(strings.NewReader(template), &out, secrets, nil, WildcardStyle{}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "hunter2") {
+		t.Errorf("expected the unfiltered value not to be rendered, got %q", out.String())
+	}
+	if out.String() != template {
+		t.Errorf("expected the placeholders to be left in place, got %q", out.String())
+	}
+}
+
+func TestWildcardSecret_ApplyFilters_FirstFieldInOrder(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		s, _ := NewSecret("{{ secret/c!* | base64decode }}")
+		wildcard := s.(*WildcardSecret)
+		wildcard.fields = map[string]string{"a": "aGk=", "b": "bad!", "c": "bad!", "d": "bad!"}
+		err := wildcard.ApplyFilters()
+		if err == nil || !strings.HasPrefix(err.Error(), "field b: ") {
+			t.Fatalf("expected an error for field b, got %v", err)
+		}
+	}
+}
+
+func TestApplyFilters_EmptyValue(t *testing.T) {
+	s, _ := NewSecret("secret/a!b | base64")
+
+	err := ApplyFilters(map[string]Secret{"p": s})
+	if err != nil {
+		t.Error(err)
+	}
+	if s.Value() != "" {
+		t.Errorf("expected an empty value to be left alone, got %q", s.Value())
+	}
+}
+
+func TestRegisterFilter(t *testing.T) {
+	RegisterFilter("reverse", func(value string, args []string) (string, error) {
+		r := []rune(value)
+		for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+			r[i], r[j] = r[j], r[i]
+		}
+		return string(r), nil
+	})
+	defer delete(filters, "reverse")
+
+	s, err := NewSecret("secret/a!b:hello | reverse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ApplyFilters(map[string]Secret{"p": s}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Value() != "olleh" {
+		t.Errorf("expected 'olleh', got %q", s.Value())
+	}
+}
+
+func TestNewSecret_UnknownFilter(t *testing.T) {
+	_, err := NewSecret("secret/a!b | nonexistent")
+	if err == nil || !strings.Contains(err.Error(), `unknown filter "nonexistent"`) {
+		t.Errorf("expected an unknown filter error, got %v", err)
+	}
+}
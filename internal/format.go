@@ -0,0 +1,304 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format - a template file format, which determines how secret values are escaped when they are
+// rendered
+type Format interface {
+	// Escape - escape value for insertion after prefix, the rendered text preceding the
+	// placeholder on the same line
+	Escape(prefix, value string) string
+}
+
+var formats = map[string]Format{
+	"dotenv":     dotenvFormat{},
+	"json":       jsonFormat{},
+	"properties": propertiesFormat{},
+	"raw":        rawFormat{},
+	"xml":        xmlFormat{},
+	"yaml":       yamlFormat{},
+}
+
+var formatExtensions = map[string]string{
+	".env":        "dotenv",
+	".json":       "json",
+	".properties": "properties",
+	".xml":        "xml",
+	".yaml":       "yaml",
+	".yml":        "yaml",
+}
+
+// FormatByName - look up a format by name, e.g. "json"
+func FormatByName(name string) (Format, error) {
+	f, ok := formats[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q, valid formats are %v", name, FormatNames())
+	}
+	return f, nil
+}
+
+// FormatNames - the names of all known formats, sorted
+func FormatNames() []string {
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DetectFormat - guess the format of a file from its name, defaulting to raw (no escaping)
+func DetectFormat(filename string) Format {
+	return formats[detectFormatName(filename)]
+}
+
+func detectFormatName(filename string) string {
+	base := strings.ToLower(filepath.Base(filename))
+	if base == ".env" || strings.HasPrefix(base, ".env.") {
+		return "dotenv"
+	}
+	if name, ok := formatExtensions[filepath.Ext(base)]; ok {
+		return name
+	}
+	return "raw"
+}
+
+// rawFormat - values are inserted unchanged
+type rawFormat struct{}
+
+func (rawFormat) Escape(prefix, value string) string {
+	return value
+}
+
+// jsonFormat - values inside a string are escaped, values outside one are inserted unchanged so
+// that numbers, booleans and objects can be templated
+type jsonFormat struct{}
+
+func (jsonFormat) Escape(prefix, value string) string {
+	if !jsonInString(prefix) {
+		return value
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(value) // Encoding a string can't fail
+	s := strings.TrimSuffix(b.String(), "\n")
+	return s[1 : len(s)-1]
+}
+
+// jsonInString - whether the end of prefix is inside a JSON string
+func jsonInString(prefix string) bool {
+	inside := false
+	for i := 0; i < len(prefix); i++ {
+		switch prefix[i] {
+		case '\\':
+			if inside {
+				i++
+			}
+		case '"':
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// propertiesFormat - Java properties, escaped so that java.util.Properties reads back the
+// original value
+type propertiesFormat struct{}
+
+func (propertiesFormat) Escape(prefix, value string) string {
+	trimmed := strings.TrimLeft(prefix, " \t\f")
+	if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "!") {
+		return value // A comment
+	}
+
+	isKey, atValueStart := propertiesPosition(trimmed)
+
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case r == ' ' && (isKey || (atValueStart && i == 0)):
+			b.WriteString(`\ `)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case isKey && (r == '=' || r == ':' || r == '#' || r == '!'):
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			for _, u := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(&b, `\u%04X`, u)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// EscapeKey - escape a key written at the start of a line
+func (f propertiesFormat) EscapeKey(key string) string {
+	return f.Escape("", key)
+}
+
+// propertiesPosition - whether text following the (left-trimmed) prefix is part of the key, and
+// if not, whether it starts the value
+func propertiesPosition(prefix string) (isKey bool, atValueStart bool) {
+	for i := 0; i < len(prefix); i++ {
+		switch prefix[i] {
+		case '\\':
+			i++
+		case '=', ':', ' ', '\t', '\f':
+			rest := strings.TrimLeft(prefix[i:], " \t\f")
+			if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+				rest = strings.TrimLeft(rest[1:], " \t\f")
+			}
+			return false, rest == ""
+		}
+	}
+	return true, false
+}
+
+// yamlFormat - values are escaped according to the kind of scalar they appear in
+type yamlFormat struct{}
+
+var yamlPlain = regexp.MustCompile(`^[A-Za-z0-9_./+=@-]+$`)
+
+// yaml11NonString - plain scalars which YAML 1.1 parsers read as booleans or numbers, though YAML
+// 1.2 reads them as strings, e.g. `on` or `0b101`
+var yaml11NonString = regexp.MustCompile(`^(?:[yYnN]|yes|Yes|YES|no|No|NO|on|On|ON|off|Off|OFF|` +
+	`[-+]?0b[01_]+|[-+]?0x[0-9a-fA-F_]+|[-+]?[0-9][0-9_]*|` +
+	`[-+]?(?:[0-9][0-9_]*)?\.[0-9_]*(?:[eE][-+]?[0-9]+)?)$`)
+
+func (yamlFormat) Escape(prefix, value string) string {
+	single, double := yamlQuoteState(prefix)
+	switch {
+	case double:
+		return yamlDoubleQuoted(value)
+	case single:
+		return strings.Replace(value, "'", "''", -1)
+	case strings.TrimSpace(prefix) == "":
+		// Presumably a line of a block scalar, so continue the indentation
+		return strings.Replace(value, "\n", "\n"+prefix, -1)
+	case yamlPlainString(value):
+		return value
+	}
+	return `"` + yamlDoubleQuoted(value) + `"`
+}
+
+// yamlPlainString - whether value can be written unquoted and still be read as the same string,
+// rather than e.g. a boolean, null or number
+func yamlPlainString(value string) bool {
+	if !yamlPlain.MatchString(value) || yaml11NonString.MatchString(value) {
+		return false
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err != nil || len(doc.Content) != 1 {
+		return false
+	}
+	n := doc.Content[0]
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!str" && n.Value == value
+}
+
+func yamlQuoteState(prefix string) (single bool, double bool) {
+	for i := 0; i < len(prefix); i++ {
+		switch c := prefix[i]; {
+		case double && c == '\\':
+			i++
+		case !single && c == '"':
+			double = !double
+		case !double && c == '\'':
+			single = !single
+		}
+	}
+	return single, double
+}
+
+func yamlDoubleQuoted(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch {
+		case r == '\\' || r == '"':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// xmlFormat - values are escaped for text content, or attribute values when inside a tag
+type xmlFormat struct{}
+
+var xmlReplacer = strings.NewReplacer(
+	"&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;",
+)
+
+var xmlAttributeReplacer = strings.NewReplacer(
+	"&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;",
+	"\n", "&#xA;", "\r", "&#xD;", "\t", "&#x9;",
+)
+
+func (xmlFormat) Escape(prefix, value string) string {
+	if strings.LastIndex(prefix, "<") > strings.LastIndex(prefix, ">") {
+		return xmlAttributeReplacer.Replace(value)
+	}
+	return xmlReplacer.Replace(value)
+}
+
+// dotenvFormat - values are quoted as a POSIX shell would need them
+type dotenvFormat struct{}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]+$`)
+
+func (dotenvFormat) Escape(prefix, value string) string {
+	single, double := shellQuoteState(prefix)
+	switch {
+	case double:
+		return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(value)
+	case single:
+		return strings.Replace(value, "'", `'\''`, -1)
+	case shellSafe.MatchString(value):
+		return value
+	}
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+func shellQuoteState(prefix string) (single bool, double bool) {
+	for i := 0; i < len(prefix); i++ {
+		switch c := prefix[i]; {
+		case !single && c == '\\':
+			i++
+		case !single && c == '"':
+			double = !double
+		case !double && c == '\'':
+			single = !single
+		}
+	}
+	return single, double
+}
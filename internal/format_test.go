@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"testing"
+)
+
+var escapeTests = []struct {
+	format   string
+	prefix   string
+	value    string
+	expected string
+}{
+	{"raw", `"a": "`, `p"w\d`, `p"w\d`},
+
+	{"json", `  "password": "`, `p"w\d`, `p\"w\\d`},
+	{"json", `  "password": "`, "line1\nline2\t<&>", `line1\nline2\t<&>`},
+	{"json", `  "port": `, `8080`, `8080`},
+	{"json", `  "a": "x\"y", "b": "`, `"`, `\"`},
+
+	{"properties", `secret=`, `a=b:c#d!e`, `a=b:c#d!e`},
+	{"properties", `secret=`, "line1\nline2", `line1\nline2`},
+	{"properties", `secret=`, `C:\dir`, `C:\\dir`},
+	{"properties", `secret=`, `  leading`, `\  leading`},
+	{"properties", `secret = foo `, `bar baz`, `bar baz`},
+	{"properties", `secret=`, "caf\u00e9 \U0001F600", `caf\u00E9 \uD83D\uDE00`},
+	{"properties", ``, `my key=x`, `my\ key\=x`},
+	{"properties", `# comment `, "a=b", "a=b"},
+
+	{"yaml", `password: "`, `p"w\d`, `p\"w\\d`},
+	{"yaml", `password: '`, `it's`, `it''s`},
+	{"yaml", `password: `, `simple-value_1`, `simple-value_1`},
+	{"yaml", `password: `, `a: b # c`, `"a: b # c"`},
+	{"yaml", `enabled: `, `on`, `"on"`},
+	{"yaml", `enabled: `, `yes`, `"yes"`},
+	{"yaml", `enabled: `, `true`, `"true"`},
+	{"yaml", `value: `, `null`, `"null"`},
+	{"yaml", `value: `, `~`, `"~"`},
+	{"yaml", `port: `, `8080`, `"8080"`},
+	{"yaml", `port: `, `1e3`, `"1e3"`},
+	{"yaml", `mode: `, `0o755`, `"0o755"`},
+	{"yaml", `value: `, `.inf`, `".inf"`},
+	{"yaml", `date: `, `2001-12-14`, `"2001-12-14"`},
+	{"yaml", `user: `, `@admin`, `"@admin"`},
+	{"yaml", `version: `, `1.2.3`, `1.2.3`},
+	{"yaml", `password: `, "line1\nline2", `"line1\nline2"`},
+	{"yaml", `    `, "line1\nline2", "line1\n    line2"},
+
+	{"xml", `<password>`, `a<b&c>"d'`, `a&lt;b&amp;c&gt;&quot;d&apos;`},
+	{"xml", `<entry value="`, "a\nb\"", `a&#xA;b&quot;`},
+
+	{"dotenv", `PASSWORD=`, `simple`, `simple`},
+	{"dotenv", `PASSWORD=`, `it's $HOME`, `'it'\''s $HOME'`},
+	{"dotenv", `PASSWORD="`, "a\"b$c`d\\e", "a\\\"b\\$c\\`d\\\\e"},
+	{"dotenv", `PASSWORD='`, `it's`, `it'\''s`},
+}
+
+func TestEscape(t *testing.T) {
+	for _, tc := range escapeTests {
+		f, err := FormatByName(tc.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := f.Escape(tc.prefix, tc.value)
+		if result != tc.expected {
+			t.Errorf("%s.Escape(%q, %q): expected %q, got %q", tc.format, tc.prefix, tc.value, tc.expected, result)
+		}
+	}
+}
+
+func TestFormatByName_Unknown(t *testing.T) {
+	_, err := FormatByName("toml")
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+var detectFormatTests = []struct {
+	filename string
+	expected Format
+}{
+	{"../examples/example.json", jsonFormat{}},
+	{"app.PROPERTIES", propertiesFormat{}},
+	{"values.yml", yamlFormat{}},
+	{"values.yaml", yamlFormat{}},
+	{"pom.xml", xmlFormat{}},
+	{".env", dotenvFormat{}},
+	{".env.production", dotenvFormat{}},
+	{"production.env", dotenvFormat{}},
+	{"generic.conf", rawFormat{}},
+	{"file1.in", rawFormat{}},
+}
+
+func TestDetectFormat(t *testing.T) {
+	for _, tc := range detectFormatTests {
+		result := DetectFormat(tc.filename)
+		if result != tc.expected {
+			t.Errorf("DetectFormat(%s): expected %T, got %T", tc.filename, tc.expected, result)
+		}
+	}
+}
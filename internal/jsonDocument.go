@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// jsonObject - a JSON object which keeps its keys in their original order
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+// jsonDocument - a JSON document, which is re-encoded with the indentation of the original
+type jsonDocument struct {
+	root            interface{}
+	indent          string // Empty if the original was on a single line
+	trailingNewline bool
+}
+
+var jsonIndent = regexp.MustCompile(`\n([ \t]+)\S`)
+
+func parseJSONDocument(r io.Reader) (structuredDocument, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	root, err := parseJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the top-level value")
+	}
+
+	doc := &jsonDocument{
+		root:            root,
+		trailingNewline: bytes.HasSuffix(raw, []byte("\n")),
+	}
+	if m := jsonIndent.FindSubmatch(raw); m != nil {
+		doc.indent = string(m[1])
+	}
+	return doc, nil
+}
+
+func parseJSONValue(dec *json.Decoder) (interface{}, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case json.Delim('{'):
+		obj := jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := parseJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonMember{key: key.(string), value: value})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			value, err := parseJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err = dec.Token()
+		return arr, err
+	}
+	return t, nil
+}
+
+func (d *jsonDocument) walk(f func(s string) (interface{}, error)) (err error) {
+	d.root, err = walkJSON(d.root, f)
+	return err
+}
+
+func walkJSON(v interface{}, f func(s string) (interface{}, error)) (interface{}, error) {
+	var err error
+	switch v := v.(type) {
+	case jsonObject:
+		for i := range v {
+			if v[i].value, err = walkJSON(v[i].value, f); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			if v[i], err = walkJSON(v[i], f); err != nil {
+				return nil, err
+			}
+		}
+	case string:
+		return f(v)
+	}
+	return v, nil
+}
+
+func (d *jsonDocument) encode(w io.Writer) error {
+	var b bytes.Buffer
+	if err := d.write(&b, d.root, 0); err != nil {
+		return err
+	}
+	if d.trailingNewline {
+		b.WriteString("\n")
+	}
+	_, err := b.WriteTo(w)
+	return err
+}
+
+func (d *jsonDocument) write(b *bytes.Buffer, v interface{}, depth int) error {
+	switch v := v.(type) {
+	case jsonObject:
+		return d.writeCollection(b, "{", "}", len(v), depth, func(i int) error {
+			if err := d.writeScalar(b, v[i].key); err != nil {
+				return err
+			}
+			b.WriteString(":")
+			if d.indent != "" {
+				b.WriteString(" ")
+			}
+			return d.write(b, v[i].value, depth+1)
+		})
+	case map[string]interface{}:
+		// Values retrieved from Vault have no order, so sort them for stable output
+		obj := jsonObject{}
+		for key, value := range v {
+			obj = append(obj, jsonMember{key: key, value: value})
+		}
+		sort.Slice(obj, func(i, j int) bool { return obj[i].key < obj[j].key })
+		return d.write(b, obj, depth)
+	case []interface{}:
+		return d.writeCollection(b, "[", "]", len(v), depth, func(i int) error {
+			return d.write(b, v[i], depth+1)
+		})
+	}
+	return d.writeScalar(b, v)
+}
+
+func (d *jsonDocument) writeCollection(b *bytes.Buffer, open, close string, n int, depth int,
+	writeItem func(i int) error) error {
+	b.WriteString(open)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		d.newline(b, depth+1)
+		if err := writeItem(i); err != nil {
+			return err
+		}
+	}
+	if n > 0 {
+		d.newline(b, depth)
+	}
+	b.WriteString(close)
+	return nil
+}
+
+func (d *jsonDocument) newline(b *bytes.Buffer, depth int) {
+	if d.indent != "" {
+		b.WriteString("\n")
+		b.WriteString(strings.Repeat(d.indent, depth))
+	}
+}
+
+func (d *jsonDocument) writeScalar(b *bytes.Buffer, v interface{}) error {
+	var scalar bytes.Buffer
+	enc := json.NewEncoder(&scalar)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	b.Write(bytes.TrimSuffix(scalar.Bytes(), []byte("\n")))
+	return nil
+}
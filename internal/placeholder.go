@@ -0,0 +1,496 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/al4/talebearer/backend"
+)
+
+// Placeholder - a parsed secret placeholder
+//
+// The grammar inside the braces is:
+//
+//	placeholder = [ scheme ":" ] path [ "!" key ] [ "@" version ] [ "#" selector ] [ ":" fallback ] { "|" modifier { arg } }
+//
+// A scheme chooses the backend the secret is read from, e.g. `{{ env:HOME }}`, and is Vault if
+// not given. The key is optional with a scheme, and is backend.ValueKey if not given. A path which
+// starts with something that looks like a scheme can be quoted.
+//
+// A version reads that version of a KV v2 secret rather than the latest, e.g.
+// `{{ secret/db!password@7 }}`. A `@` which isn't followed by a number is part of the key, e.g.
+// `{{ secret/app!admin@example.com }}`.
+//
+// A key of `*` is a wildcard, which stands for every field of the document, e.g.
+// `{{ secret/app!* }}`. A quoted `"*"` is a key named `*`.
+//
+// A selector picks a field out of a value which is a map or list, or a string containing a JSON
+// document, e.g. `{{ secret/gcp!credentials.json#client_email }}`. A `#` which isn't followed by a
+// valid selector is part of the key, but a key such as `pass#word` has to be quoted.
+//
+// Any segment may be double-quoted to include the separator characters, e.g.
+// `{{ secret/example!key:"a|b" }}`. Inside quotes, `\"` and `\\` are escapes.
+type Placeholder struct {
+	Raw         string     // The placeholder as written, including braces
+	Scheme      string     // Backend to read the secret from, empty for the default
+	Path        string     // Document path inside Vault
+	Key         string     // Key inside a Vault document
+	Wildcard    bool       // Whether the key is `*`, meaning every key in the document
+	Version     int        // Version of a KV v2 document, 0 for the latest
+	Selector    string     // Path to a field inside the value, empty if none
+	Fallback    string     // Value to use if the secret can't be retrieved
+	HasFallback bool       // Whether a fallback was given (it may be empty)
+	Modifiers   []Modifier // Modifiers applied to the value, in order
+}
+
+// Modifier - a named modifier and its arguments, e.g. `| indent 4`
+type Modifier struct {
+	Name string
+	Args []string
+}
+
+// SyntaxError - a placeholder which could not be parsed
+type SyntaxError struct {
+	Line   int // Line number in the template, 0 if unknown
+	Column int // Column of the offending character, counting from 1
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("column %d: %s", e.Column, e.Msg)
+}
+
+// ParsePlaceholder - parse a placeholder, with or without the surrounding braces
+func ParsePlaceholder(placeholder string) (*Placeholder, error) {
+	inner := trimBrackets(placeholder)
+	offset := strings.Index(placeholder, inner)
+
+	tokens, err := lex(inner, offset)
+	if err != nil {
+		return nil, columnError(placeholder, err)
+	}
+
+	p := &parser{tokens: tokens}
+	ph, err := p.parse()
+	if err != nil {
+		return nil, columnError(placeholder, err)
+	}
+	ph.Raw = placeholder
+	return ph, nil
+}
+
+// UsesScheme - whether any of the placeholders reads from the backend with the given scheme
+func UsesScheme(placeholders []string, scheme string) bool {
+	for _, placeholder := range placeholders {
+		p, err := ParsePlaceholder(placeholder)
+		if err != nil {
+			continue
+		}
+		if p.Scheme == scheme || (p.Scheme == "" && scheme == backend.DefaultScheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPlaceholder - whether the text inside braces is meant as a placeholder, because it has a `!`
+// separating a path from a key or starts with the scheme of a known backend
+// Anything else, such as Helm's `{{ .Values.name }}`, is left alone as literal text.
+func isPlaceholder(text string) bool {
+	tokens, err := lex(trimBrackets(text), 0)
+	if err != nil {
+		// Only quoting can fail to lex, leave it to the parser to report if it's meant as a secret
+		return strings.Contains(text, "!")
+	}
+	if tokens[0].typ == tokenText && tokens[1].typ == tokenColon && knownScheme(tokens[0].value) {
+		return true
+	}
+	for _, t := range tokens {
+		if t.typ == tokenBang {
+			return true
+		}
+	}
+	return false
+}
+
+// knownScheme - whether scheme is the default or that of a registered backend
+func knownScheme(scheme string) bool {
+	if scheme == backend.DefaultScheme {
+		return true
+	}
+	_, ok := backend.Registered()[scheme]
+	return ok
+}
+
+func trimBrackets(placeholder string) string {
+	placeholder = strings.TrimSpace(placeholder)
+	if strings.HasPrefix(placeholder, "{{") && strings.HasSuffix(placeholder, "}}") {
+		placeholder = strings.TrimPrefix(placeholder, "{{")
+		placeholder = strings.TrimSuffix(placeholder, "}}")
+	}
+	placeholder = strings.TrimSpace(placeholder)
+	return placeholder
+}
+
+// posError - a syntax error at a byte offset, converted to a SyntaxError by columnError
+type posError struct {
+	pos int
+	msg string
+}
+
+func (e *posError) Error() string { return e.msg }
+
+func columnError(input string, err error) error {
+	pe, ok := err.(*posError)
+	if !ok {
+		return err
+	}
+	if pe.pos > len(input) {
+		pe.pos = len(input)
+	}
+	return &SyntaxError{
+		Column: utf8.RuneCountInString(input[:pe.pos]) + 1,
+		Msg:    pe.msg,
+	}
+}
+
+type tokenType int
+
+const (
+	tokenEOF    tokenType = iota
+	tokenText             // A run of unquoted characters
+	tokenSpace            // A run of whitespace
+	tokenQuoted           // A double-quoted string, with escapes resolved
+	tokenBang             // !
+	tokenColon            // :
+	tokenPipe             // |
+	tokenHash             // #
+	tokenAt               // @
+)
+
+var punctuation = map[rune]tokenType{
+	'!': tokenBang,
+	':': tokenColon,
+	'|': tokenPipe,
+	'#': tokenHash,
+	'@': tokenAt,
+}
+
+type token struct {
+	typ   tokenType
+	value string
+	pos   int // Byte offset of the token in the placeholder
+}
+
+// lex - split the inside of a placeholder into tokens
+func lex(input string, offset int) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		r, size := utf8.DecodeRuneInString(input[i:])
+		start := i
+		switch {
+		case r == '"':
+			value, n, err := lexQuoted(input[i:])
+			if err != nil {
+				return nil, &posError{pos: offset + start, msg: err.Error()}
+			}
+			tokens = append(tokens, token{typ: tokenQuoted, value: value, pos: offset + start})
+			i += n
+		case punctuation[r] != tokenEOF:
+			tokens = append(tokens, token{typ: punctuation[r], value: string(r), pos: offset + start})
+			i += size
+		case unicode.IsSpace(r):
+			i = scanWhile(input, i, func(r rune) bool { return unicode.IsSpace(r) })
+			tokens = append(tokens, token{typ: tokenSpace, value: input[start:i], pos: offset + start})
+		default:
+			i = scanWhile(input, i, func(r rune) bool {
+				return r != '"' && punctuation[r] == tokenEOF && !unicode.IsSpace(r)
+			})
+			tokens = append(tokens, token{typ: tokenText, value: input[start:i], pos: offset + start})
+		}
+	}
+	return resolveMarkers(append(tokens, token{typ: tokenEOF, pos: offset + len(input)})), nil
+}
+
+// resolveMarkers - treat a `@` as part of the text around it unless a version number follows it,
+// and a `#` unless a valid selector follows it, so that keys such as `admin@example.com` or
+// ending in `#` don't need quoting
+func resolveMarkers(tokens []token) []token {
+	for i, t := range tokens {
+		switch {
+		case t.typ == tokenAt && !versionFollows(tokens[i+1:]):
+			tokens[i].typ = tokenText
+		case t.typ == tokenHash && !selectorFollows(tokens[i+1:]):
+			tokens[i].typ = tokenText
+		}
+	}
+	return tokens
+}
+
+// versionFollows - whether the tokens start with digits running up to a separator, whitespace or
+// the end
+func versionFollows(tokens []token) bool {
+	if tokens[0].typ != tokenText || strings.Trim(tokens[0].value, "0123456789") != "" {
+		return false
+	}
+	return tokens[1].typ != tokenQuoted
+}
+
+// selectorFollows - whether the tokens up to the next `!`, `:` or `|` are a valid selector
+func selectorFollows(tokens []token) bool {
+	p := &parser{tokens: tokens}
+	sel := p.segment(tokenBang, tokenColon, tokenPipe)
+	if _, err := parseSelector(sel); err != nil || sel == "" {
+		return false
+	}
+	return p.peek().typ != tokenBang
+}
+
+func scanWhile(input string, i int, f func(rune) bool) int {
+	for i < len(input) {
+		r, size := utf8.DecodeRuneInString(input[i:])
+		if !f(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// lexQuoted - read a double-quoted string from the start of input, returning its value and
+// the number of bytes consumed
+func lexQuoted(input string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(input); i++ {
+		switch c := input[i]; c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 == len(input) {
+				break
+			}
+			i++
+			switch input[i] {
+			case '"', '\\':
+				b.WriteByte(input[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				return "", 0, fmt.Errorf("unknown escape sequence `\\%c` in quoted string", input[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quoted string")
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &posError{pos: t.pos, msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) parse() (*Placeholder, error) {
+	ph := &Placeholder{}
+
+	start := p.peek()
+	if start.typ == tokenEOF {
+		return nil, p.errorf(start, "empty placeholder")
+	}
+
+	if start.typ == tokenText && backend.ValidScheme(start.value) && p.tokens[p.pos+1].typ == tokenColon {
+		ph.Scheme = start.value
+		p.next()
+		p.next()
+	}
+
+	if ph.Scheme == "" {
+		ph.Path = p.segment(tokenBang, tokenPipe)
+	} else {
+		// The key is optional with a scheme, so the path can be followed by anything after a key
+		ph.Path = p.segment(tokenBang, tokenAt, tokenHash, tokenColon, tokenPipe)
+	}
+
+	switch {
+	case p.peek().typ == tokenBang && ph.Path == "":
+		return nil, p.errorf(p.peek(), "missing path before `!`")
+	case p.peek().typ == tokenBang:
+		if err := p.key(ph); err != nil {
+			return nil, err
+		}
+	case ph.Scheme == "":
+		return nil, p.errorf(start, "path does not contain a `!` separator")
+	case ph.Path == "":
+		return nil, p.errorf(p.peek(), "missing path after `%s:`", ph.Scheme)
+	default:
+		ph.Key = backend.ValueKey
+	}
+
+	if p.peek().typ == tokenAt {
+		at := p.next()
+		version := p.segment(tokenBang, tokenAt, tokenHash, tokenColon, tokenPipe)
+		if t := p.peek(); t.typ == tokenBang || t.typ == tokenAt {
+			return nil, p.errorf(t, "unexpected %q in version", t.value)
+		}
+		n, err := strconv.Atoi(version)
+		if err != nil || n < 1 {
+			return nil, p.errorf(at, "invalid version %q, must be a positive integer", version)
+		}
+		ph.Version = n
+	}
+
+	if t := p.peek(); ph.Wildcard && t.typ == tokenHash {
+		return nil, p.errorf(t, "a wildcard can't have a selector")
+	}
+	if t := p.peek(); ph.Wildcard && t.typ == tokenColon {
+		return nil, p.errorf(t, "a wildcard can't have a fallback")
+	}
+
+	if p.peek().typ == tokenHash {
+		hash := p.next()
+		ph.Selector = p.segment(tokenBang, tokenColon, tokenPipe)
+		if t := p.peek(); t.typ == tokenBang {
+			return nil, p.errorf(t, "unexpected `!` in selector")
+		}
+		if ph.Selector == "" {
+			return nil, p.errorf(hash, "missing selector after `#`")
+		}
+		if _, err := parseSelector(ph.Selector); err != nil {
+			return nil, p.errorf(hash, "%s", err)
+		}
+	}
+
+	if p.peek().typ == tokenColon {
+		p.next()
+		ph.Fallback = p.segment(tokenPipe)
+		ph.HasFallback = true
+	}
+
+	for p.peek().typ == tokenPipe {
+		m, err := p.modifier()
+		if err != nil {
+			return nil, err
+		}
+		ph.Modifiers = append(ph.Modifiers, m)
+	}
+
+	if t := p.peek(); t.typ != tokenEOF {
+		return nil, p.errorf(t, "unexpected %q", t.value)
+	}
+	return ph, nil
+}
+
+// key - parse `!key`
+func (p *parser) key(ph *Placeholder) error {
+	bang := p.next()
+
+	keyStart := p.pos
+	ph.Key = p.segment(tokenBang, tokenAt, tokenHash, tokenColon, tokenPipe)
+	if t := p.peek(); t.typ == tokenBang {
+		return p.errorf(t, "unexpected second `!` in key")
+	}
+	if ph.Key == "" {
+		return p.errorf(bang, "missing key after `!`")
+	}
+	ph.Wildcard = p.isWildcard(keyStart)
+	return nil
+}
+
+// segment - consume tokens up to one of the stop types, returning their value with surrounding
+// unquoted whitespace removed
+func (p *parser) segment(stop ...tokenType) string {
+	var parts []token
+	for !isOneOf(p.peek().typ, append(stop, tokenEOF)...) {
+		parts = append(parts, p.next())
+	}
+	for len(parts) > 0 && parts[0].typ == tokenSpace {
+		parts = parts[1:]
+	}
+	for len(parts) > 0 && parts[len(parts)-1].typ == tokenSpace {
+		parts = parts[:len(parts)-1]
+	}
+
+	var b strings.Builder
+	for _, t := range parts {
+		b.WriteString(t.value)
+	}
+	return b.String()
+}
+
+// isWildcard - whether the tokens from start to the current position are a single unquoted `*`
+func (p *parser) isWildcard(start int) bool {
+	var words []token
+	for _, t := range p.tokens[start:p.pos] {
+		if t.typ != tokenSpace {
+			words = append(words, t)
+		}
+	}
+	return len(words) == 1 && words[0].typ == tokenText && words[0].value == "*"
+}
+
+// modifier - parse `| name arg...`
+func (p *parser) modifier() (Modifier, error) {
+	pipe := p.next()
+
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for !isOneOf(p.peek().typ, tokenPipe, tokenEOF) {
+		t := p.next()
+		if t.typ == tokenSpace {
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		}
+		if !inWord && len(words) == 0 && t.typ != tokenText {
+			return Modifier{}, p.errorf(t, "expected modifier name, got %q", t.value)
+		}
+		word.WriteString(t.value)
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	if len(words) == 0 {
+		return Modifier{}, p.errorf(pipe, "expected modifier name after `|`")
+	}
+	return Modifier{Name: words[0], Args: words[1:]}, nil
+}
+
+func isOneOf(typ tokenType, types ...tokenType) bool {
+	for _, t := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
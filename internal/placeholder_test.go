@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var parsePlaceholderTests = []struct {
+	input    string
+	expected Placeholder
+}{
+	{"secret/example!key", Placeholder{Path: "secret/example", Key: "key"}},
+	{"{{ secret/example!key }}", Placeholder{Path: "secret/example", Key: "key"}},
+	{"{{secret/example!key}}", Placeholder{Path: "secret/example", Key: "key"}},
+	{"{{ secret/my app ! key }}", Placeholder{Path: "secret/my app", Key: "key"}},
+	{"{{ secret/example!key:fallback }}", Placeholder{
+		Path: "secret/example", Key: "key", Fallback: "fallback", HasFallback: true,
+	}},
+	{"{{ secret/example!key: }}", Placeholder{
+		Path: "secret/example", Key: "key", Fallback: "", HasFallback: true,
+	}},
+	{"{{ secret/example!key:a:b!c }}", Placeholder{
+		Path: "secret/example", Key: "key", Fallback: "a:b!c", HasFallback: true,
+	}},
+	{`{{ secret/example!key:"a | b }}" }}`, Placeholder{
+		Path: "secret/example", Key: "key", Fallback: "a | b }}", HasFallback: true,
+	}},
+	{`{{ "secret/with!bang"!"key \"quoted\"" }}`, Placeholder{
+		Path: "secret/with!bang", Key: `key "quoted"`,
+	}},
+	{"{{ secret/gcp!credentials.json#client_email }}", Placeholder{
+		Path: "secret/gcp", Key: "credentials.json", Selector: "client_email",
+	}},
+	{`{{ secret/gcp!creds#a.b[0]["c.d"]:fb }}`, Placeholder{
+		Path: "secret/gcp", Key: "creds", Selector: "a.b[0][c.d]", Fallback: "fb", HasFallback: true,
+	}},
+	{"{{ secret/example!key# }}", Placeholder{
+		Path: "secret/example", Key: "key#",
+	}},
+	{"{{ secret/example!key#a..b:fb }}", Placeholder{
+		Path: "secret/example", Key: "key#a..b", Fallback: "fb", HasFallback: true,
+	}},
+	{`{{ secret/example!"pass#word" }}`, Placeholder{
+		Path: "secret/example", Key: "pass#word",
+	}},
+	{"{{ secret/db!password@7 }}", Placeholder{
+		Path: "secret/db", Key: "password", Version: 7,
+	}},
+	{"{{ secret/db!creds@12#user:admin@example.com }}", Placeholder{
+		Path: "secret/db", Key: "creds", Version: 12, Selector: "user", Fallback: "admin@example.com",
+		HasFallback: true,
+	}},
+	{`{{ secret/example!"key@host" }}`, Placeholder{
+		Path: "secret/example", Key: "key@host",
+	}},
+	{"{{ secret/app!admin@example.com }}", Placeholder{
+		Path: "secret/app", Key: "admin@example.com",
+	}},
+	{"{{ secret/app!key@latest:fb }}", Placeholder{
+		Path: "secret/app", Key: "key@latest", Fallback: "fb", HasFallback: true,
+	}},
+	{"{{ secret/app!key@ }}", Placeholder{
+		Path: "secret/app", Key: "key@",
+	}},
+	{"{{ secret/app!user@2fa@3 }}", Placeholder{
+		Path: "secret/app", Key: "user@2fa", Version: 3,
+	}},
+	{"{{ vault:secret/example!key }}", Placeholder{
+		Scheme: "vault", Path: "secret/example", Key: "key",
+	}},
+	{"{{ file:/run/secrets/db!password:fb }}", Placeholder{
+		Scheme: "file", Path: "/run/secrets/db", Key: "password", Fallback: "fb", HasFallback: true,
+	}},
+	{"{{ env:DB_PASSWORD }}", Placeholder{
+		Scheme: "env", Path: "DB_PASSWORD", Key: "value",
+	}},
+	{"{{ env:DB_PASSWORD:changeme | trim }}", Placeholder{
+		Scheme: "env", Path: "DB_PASSWORD", Key: "value", Fallback: "changeme", HasFallback: true,
+		Modifiers: []Modifier{{Name: "trim", Args: []string{}}},
+	}},
+	{"{{ env:GCP_CREDENTIALS#client_email }}", Placeholder{
+		Scheme: "env", Path: "GCP_CREDENTIALS", Key: "value", Selector: "client_email",
+	}},
+	{`{{ "c:secret"!key }}`, Placeholder{
+		Path: "c:secret", Key: "key",
+	}},
+	{"{{ secret/app!* }}", Placeholder{
+		Path: "secret/app", Key: "*", Wildcard: true,
+	}},
+	{`{{ secret/app!"*" }}`, Placeholder{
+		Path: "secret/app", Key: "*",
+	}},
+	{"{{ secret/app! * @2 | upper }}", Placeholder{
+		Path: "secret/app", Key: "*", Wildcard: true, Version: 2, Modifiers: []Modifier{
+			{Name: "upper", Args: []string{}},
+		},
+	}},
+	{"{{ secret/example!key | indent 4 | upper }}", Placeholder{
+		Path: "secret/example", Key: "key", Modifiers: []Modifier{
+			{Name: "indent", Args: []string{"4"}},
+			{Name: "upper", Args: []string{}},
+		},
+	}},
+	{`{{ secret/example!key:fb | replace ":" "a b" }}`, Placeholder{
+		Path: "secret/example", Key: "key", Fallback: "fb", HasFallback: true, Modifiers: []Modifier{
+			{Name: "replace", Args: []string{":", "a b"}},
+		},
+	}},
+}
+
+func TestParsePlaceholder(t *testing.T) {
+	for _, tc := range parsePlaceholderTests {
+		p, err := ParsePlaceholder(tc.input)
+		if err != nil {
+			t.Errorf("ParsePlaceholder(%s): unexpected error: %s", tc.input, err)
+			continue
+		}
+		tc.expected.Raw = tc.input
+		if !reflect.DeepEqual(*p, tc.expected) {
+			t.Errorf("ParsePlaceholder(%s): expected %+v, got %+v", tc.input, tc.expected, *p)
+		}
+	}
+}
+
+var parsePlaceholderErrorTests = []struct {
+	input  string
+	column int
+	msg    string
+}{
+	{"{{ }}", 1, "empty placeholder"},
+	{"{{ secret/example }}", 4, "does not contain a `!` separator"},
+	{"{{ !key }}", 4, "missing path before `!`"},
+	{"{{ secret/example! }}", 18, "missing key after `!`"},
+	{"{{ secret/example!key!other }}", 22, "unexpected second `!` in key"},
+	{`{{ secret/example!"key }}`, 19, "unterminated quoted string"},
+	{`{{ secret/example!"k\ey" }}`, 19, "unknown escape sequence"},
+	{"{{ secret/example!key@0 }}", 22, "invalid version \"0\", must be a positive integer"},
+	{"{{ secret/example!key@1@2 }}", 24, "unexpected \"@\" in version"},
+	{"{{ env: }}", 8, "missing path after `env:`"},
+	{"{{ env:| upper }}", 8, "missing path after `env:`"},
+	{"{{ env:!key }}", 8, "missing path before `!`"},
+	{"{{ secret/app!*#a }}", 16, "a wildcard can't have a selector"},
+	{"{{ secret/app!*:fb }}", 16, "a wildcard can't have a fallback"},
+	{"{{ secret/example!key#a!b }}", 24, "unexpected second `!` in key"},
+	{"{{ secret/example!key | }}", 23, "expected modifier name after `|`"},
+	{`{{ secret/example!key | "upper" }}`, 25, "expected modifier name"},
+}
+
+func TestParsePlaceholder_Errors(t *testing.T) {
+	for _, tc := range parsePlaceholderErrorTests {
+		_, err := ParsePlaceholder(tc.input)
+		if err == nil {
+			t.Errorf("ParsePlaceholder(%s): expected an error", tc.input)
+			continue
+		}
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("ParsePlaceholder(%s): expected a *SyntaxError, got %T", tc.input, err)
+			continue
+		}
+		if se.Column != tc.column {
+			t.Errorf("ParsePlaceholder(%s): expected column %d, got %d", tc.input, tc.column, se.Column)
+		}
+		if !strings.Contains(se.Msg, tc.msg) {
+			t.Errorf("ParsePlaceholder(%s): expected error containing '%s', got '%s'", tc.input, tc.msg, se.Msg)
+		}
+	}
+}
+
+var scanLineTests = []struct {
+	line         string
+	placeholders []string
+	text         string // The line with placeholders left in place and escapes removed
+}{
+	{"no placeholders here", nil, "no placeholders here"},
+	{"a={{ secret/a!b }}", []string{"{{ secret/a!b }}"}, "a={{ secret/a!b }}"},
+	{"{{ secret/a!b }}{{ secret/c!d }}", []string{"{{ secret/a!b }}", "{{ secret/c!d }}"},
+		"{{ secret/a!b }}{{ secret/c!d }}"},
+	{"unclosed {{ secret/a!b } here", nil, "unclosed {{ secret/a!b } here"},
+	{"unclosed {{ then {{ secret/a!b }}", []string{"{{ secret/a!b }}"}, "unclosed {{ then {{ secret/a!b }}"},
+	{`quoted {{ secret/a!b:"}}" }} end`, []string{`{{ secret/a!b:"}}" }}`}, `quoted {{ secret/a!b:"}}" }} end`},
+	{`escaped \{{ .Values.foo }}`, nil, "escaped {{ .Values.foo }}"},
+	{`\{{ secret/a!b }} {{ secret/c!d }}`, []string{"{{ secret/c!d }}"}, "{{ secret/a!b }} {{ secret/c!d }}"},
+	{"helm {{ .Values.name }} {{ secret/a!b }}", []string{"{{ secret/a!b }}"}, "helm {{ .Values.name }} {{ secret/a!b }}"},
+	{`{{ printf "%s!" .x }} {{ env:HOME }}`, []string{"{{ env:HOME }}"}, `{{ printf "%s!" .x }} {{ env:HOME }}`},
+	{"{{ secret/example }} {{ c:x }}", nil, "{{ secret/example }} {{ c:x }}"},
+}
+
+func TestScanLine(t *testing.T) {
+	for _, tc := range scanLineTests {
+		var placeholders []string
+		var text strings.Builder
+		for _, seg := range scanLine(tc.line) {
+			text.WriteString(seg.text)
+			if seg.placeholder {
+				placeholders = append(placeholders, seg.text)
+			}
+		}
+		if !reflect.DeepEqual(placeholders, tc.placeholders) {
+			t.Errorf("scanLine(%s): expected %q, got %q", tc.line, tc.placeholders, placeholders)
+		}
+		if text.String() != tc.text {
+			t.Errorf("scanLine(%s): expected text %s, got %s", tc.line, tc.text, text.String())
+		}
+	}
+}
+
+func TestTemplateScanner_Directives(t *testing.T) {
+	lines := []string{
+		"a={{ secret/a!b }}",
+		"# talebearer:disable",
+		"b={{ .Values.b }}",
+		`c=\{{ .Values.c }}`,
+		"# talebearer:enable",
+		"d={{ secret/d!e }}",
+	}
+
+	var placeholders []string
+	var text []string
+	scanner := &templateScanner{}
+	for _, line := range lines {
+		var b strings.Builder
+		for _, seg := range scanner.scan(line) {
+			b.WriteString(seg.text)
+			if seg.placeholder {
+				placeholders = append(placeholders, seg.text)
+			}
+		}
+		text = append(text, b.String())
+	}
+
+	expected := []string{"{{ secret/a!b }}", "{{ secret/d!e }}"}
+	if !reflect.DeepEqual(placeholders, expected) {
+		t.Errorf("expected placeholders %q, got %q", expected, placeholders)
+	}
+	if !reflect.DeepEqual(text, lines) {
+		t.Errorf("expected disabled region to be passed through unchanged, got %q", text)
+	}
+}
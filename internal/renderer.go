@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FindPlaceholders - find the placeholders in a template read from r, in the order they appear
+func FindPlaceholders(r io.Reader) (placeholders []string, err error) {
+	err = eachLine(r, func(n int, segments []segment) error {
+		for _, seg := range segments {
+			if !seg.placeholder {
+				continue
+			}
+			ph, err := ParsePlaceholder(seg.text)
+			if err == nil && ph.Wildcard && wildcardIndex(segments) < 0 {
+				err = &SyntaxError{Column: 1, Msg: "a wildcard placeholder must be alone on its line"}
+			}
+			if err != nil {
+				if se, ok := err.(*SyntaxError); ok {
+					se.Line = n
+					se.Column += seg.column - 1
+				}
+				return fmt.Errorf("invalid placeholder %s: %s", seg.text, err)
+			}
+			placeholders = append(placeholders, seg.text)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return placeholders, nil
+}
+
+// Render - copy a template from r to w, substituting each placeholder with its secret escaped
+// for the given format, and expanding each wildcard placeholder into a line per field in the
+// given style
+// The template is read a line at a time, and each placeholder is replaced exactly once, so
+// secret values are never themselves scanned for placeholders.
+func Render(r io.Reader, w io.Writer, secrets map[string]Secret, format Format, style WildcardStyle) error {
+	if format == nil {
+		format = rawFormat{}
+	}
+
+	for p, s := range secrets {
+		log.Infof("Replacing %s\n", s.Path())
+		if ds, ok := s.(DocumentSecret); ok {
+			if ds.Fields() == nil {
+				log.Warnf("Not replacing %s, document was not retrieved", p)
+			}
+			continue
+		}
+		if s.Value() == "" {
+			log.Warnf("Not replacing %s, empty string value", p)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	err := eachLine(r, func(n int, segments []segment) error {
+		var line strings.Builder
+		if i := wildcardIndex(segments); i >= 0 {
+			if ds, ok := secrets[segments[i].text].(DocumentSecret); ok && ds.Fields() != nil {
+				renderFields(&line, ds.Fields(), leadingSpace(segments[:i]),
+					lineEnding(segments), style, format)
+				_, err := bw.WriteString(line.String())
+				return err
+			}
+		}
+		for _, seg := range segments {
+			if s, ok := secrets[seg.text]; ok && seg.placeholder && s.Value() != "" {
+				line.WriteString(format.Escape(line.String(), s.Value()))
+			} else {
+				line.WriteString(seg.text)
+			}
+		}
+		_, err := bw.WriteString(line.String())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// wildcardIndex - the index of the placeholder segment if it is the only thing on the line other
+// than whitespace, otherwise -1
+func wildcardIndex(segments []segment) int {
+	index := -1
+	for i, seg := range segments {
+		switch {
+		case seg.placeholder && index < 0:
+			index = i
+		case seg.placeholder || strings.TrimSpace(seg.text) != "":
+			return -1
+		}
+	}
+	return index
+}
+
+// leadingSpace - the text of the segments before a placeholder, which is only whitespace
+func leadingSpace(segments []segment) string {
+	var b strings.Builder
+	for _, seg := range segments {
+		b.WriteString(seg.text)
+	}
+	return b.String()
+}
+
+// lineEnding - the line ending segment of a line, if it has one
+func lineEnding(segments []segment) string {
+	if len(segments) > 0 && segments[len(segments)-1].text == "\n" {
+		return "\n"
+	}
+	return ""
+}
+
+// eachLine - scan each line of r and call f with the line number (counting from 1) and the
+// line's segments, the last of which is the line ending if there is one
+func eachLine(r io.Reader, f func(n int, segments []segment) error) error {
+	br := bufio.NewReader(r)
+	scanner := &templateScanner{}
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line != "" {
+			// Scan without the line ending, so it's never part of a placeholder
+			content := strings.TrimSuffix(line, "\n")
+			segments := scanner.scan(content)
+			if len(content) < len(line) {
+				segments = append(segments, segment{text: "\n"})
+			}
+			if ferr := f(n, segments); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
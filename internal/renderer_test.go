@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/al4/talebearer/vault"
+)
+
+func TestFindPlaceholders(t *testing.T) {
+	template := "a={{ secret/a!b }}\r\nb={{ secret/c!d }} {{ secret/a!b }}"
+
+	placeholders, err := FindPlaceholders(strings.NewReader(template))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"{{ secret/a!b }}", "{{ secret/c!d }}", "{{ secret/a!b }}"}, placeholders)
+}
+
+func TestFindPlaceholders_IgnoresOtherTemplateSyntax(t *testing.T) {
+	template := "name: {{ .Values.name }}\n" +
+		"{{- if .Values.enabled }}\n" +
+		"password: {{ secret/a!b }}\n" +
+		"{{- end }}\n"
+
+	placeholders, err := FindPlaceholders(strings.NewReader(template))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"{{ secret/a!b }}"}, placeholders)
+
+	secrets := make(map[string]Secret)
+	secrets["{{ secret/a!b }}"], _ = NewSecret("secret/a!b:value")
+
+	var out bytes.Buffer
+	err = Render(strings.NewReader(template), &out, secrets, nil, WildcardStyle{})
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Replace(template, "{{ secret/a!b }}", "value", 1), out.String())
+}
+
+func TestRender_PreservesLineEndings(t *testing.T) {
+	secrets := make(map[string]Secret)
+	secrets["{{ secret/a!b }}"], _ = NewSecret("secret/a!b:value")
+
+	for _, template := range []string{
+		"a={{ secret/a!b }}\nb=c\n",
+		"a={{ secret/a!b }}\r\nb=c\r\n",
+		"a={{ secret/a!b }}\nb=c",
+		"",
+	} {
+		var out bytes.Buffer
+		err := Render(strings.NewReader(template), &out, secrets, nil, WildcardStyle{})
+		assert.NoError(t, err)
+		assert.Equal(t, strings.Replace(template, "{{ secret/a!b }}", "value", -1), out.String())
+	}
+}
+
+func TestRender_DoesNotRescanValues(t *testing.T) {
+	secrets := make(map[string]Secret)
+	secrets["{{ secret/a!b }}"], _ = NewSecret("secret/a!b:{{ secret/c!d }}")
+	secrets["{{ secret/c!d }}"], _ = NewSecret("secret/c!d:oops")
+
+	var out bytes.Buffer
+	err := Render(strings.NewReader("a={{ secret/a!b }}\nc={{ secret/c!d }}\n"), &out, secrets, nil, WildcardStyle{})
+	assert.NoError(t, err)
+	assert.Equal(t, "a={{ secret/c!d }}\nc=oops\n", out.String())
+}
+
+func TestRender_LongLines(t *testing.T) {
+	secrets := make(map[string]Secret)
+	secrets["{{ secret/a!b }}"], _ = NewSecret("secret/a!b:value")
+
+	padding := strings.Repeat("x", 1<<20)
+	var out bytes.Buffer
+	err := Render(strings.NewReader(padding+"{{ secret/a!b }}"+padding), &out, secrets, nil, WildcardStyle{})
+	assert.NoError(t, err)
+	assert.Equal(t, padding+"value"+padding, out.String())
+}
+
+func TestFindPlaceholders_WildcardNotAlone(t *testing.T) {
+	_, err := FindPlaceholders(strings.NewReader("a=b\n  {{ secret/app!* }}\nc={{ secret/app!* }}\n"))
+	assert.EqualError(t, err, "invalid placeholder {{ secret/app!* }}: line 3, column 3: "+
+		"a wildcard placeholder must be alone on its line")
+}
+
+func TestRender_Wildcard(t *testing.T) {
+	secret, _ := NewSecret("{{ secret/app!* | trim }}")
+	mockClient := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"password":  " p=ss ",
+				"port":      json.Number("8080"),
+				"db.user":   "app",
+				"Dark Mode": "on",
+			},
+		},
+	}
+	mockClient.On("Read", "secret/app")
+	assert.NoError(t, secret.Retrieve(context.Background(), mockClient))
+	assert.NoError(t, ApplyFilters(map[string]Secret{"{{ secret/app!* | trim }}": secret}))
+
+	secrets := map[string]Secret{"{{ secret/app!* | trim }}": secret}
+	template := "# app\n  {{ secret/app!* | trim }}\nother=value"
+
+	var tests = []struct {
+		style    WildcardStyle
+		format   Format
+		expected string
+	}{
+		{WildcardStyle{}, nil,
+			"# app\n  Dark Mode=on\n  db.user=app\n  password=p=ss\n  port=8080\nother=value"},
+		{WildcardStyle{Prefix: "app.", KeyCase: KeyCaseLower}, propertiesFormat{},
+			"# app\n  app.dark\\ mode=on\n  app.db.user=app\n  app.password=p=ss\n  app.port=8080\nother=value"},
+		{WildcardStyle{Prefix: "APP_", Separator: ": ", KeyCase: KeyCaseUpper}, yamlFormat{},
+			"# app\n  APP_DARK MODE: \"on\"\n  APP_DB.USER: app\n  APP_PASSWORD: p=ss\n  APP_PORT: \"8080\"\nother=value"},
+	}
+	for _, tc := range tests {
+		var out bytes.Buffer
+		err := Render(strings.NewReader(template), &out, secrets, tc.format, tc.style)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, out.String())
+	}
+}
+
+func TestRender_WildcardNotRetrieved(t *testing.T) {
+	secret, _ := NewSecret("{{ secret/app!* }}")
+	secrets := map[string]Secret{"{{ secret/app!* }}": secret}
+
+	var out bytes.Buffer
+	err := Render(strings.NewReader("{{ secret/app!* }}\n"), &out, secrets, nil, WildcardStyle{})
+	assert.NoError(t, err)
+	assert.Equal(t, "{{ secret/app!* }}\n", out.String())
+}
+
+func TestNewWildcardStyle_InvalidKeyCase(t *testing.T) {
+	_, err := NewWildcardStyle("", "=", "title")
+	assert.EqualError(t, err, `unknown key case "title", valid key cases are "upper" and "lower"`)
+}
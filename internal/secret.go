@@ -1,49 +1,82 @@
 package internal
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 
-	"github.com/al4/talebearer/vault"
+	"github.com/al4/talebearer/backend"
 )
 
 // Secret - Doc TODO
 type Secret interface {
-	Retrieve(vault.Vault) error
+	Retrieve(context.Context, backend.Backend) error
+	Scheme() string
 	Value() string
 	Key() string
 	Path() string
 	SetValue(string)
 }
 
-// VaultSecret - a document from Vault
+// TypedSecret - a Secret which also keeps the value retrieved with its original type, e.g. a
+// number or a map, for templates which can represent it
+type TypedSecret interface {
+	Secret
+	TypedValue() interface{}
+}
+
+// VaultSecret - a document from Vault, or another backend if the placeholder has a scheme
 type VaultSecret struct {
-	path  string // Document path inside Vault
-	key   string // Key inside a Vault document
-	value string // Secret value
+	scheme  string      // Scheme of the backend the secret is read from
+	path    string      // Document path inside Vault
+	key     string      // Key inside a Vault document
+	version int         // Version of a KV v2 document, 0 for the latest
+	sel     selector    // Path to a field inside the value
+	filters []Modifier  // Filters to apply to the value once retrieved
+	value   string      // Secret value
+	typed   interface{} // Secret value as retrieved, nil if it was set with SetValue
 }
 
 // NewSecret creates a new Secret. The actual secret value is not yet retrieved from Vault
 func NewSecret(placeholder string) (Secret, error) {
-	s := &VaultSecret{}
-
-	if !strings.Contains(placeholder, "!") {
-		return nil, fmt.Errorf("path does not contain a `!` separator")
+	p, err := ParsePlaceholder(placeholder)
+	if err != nil {
+		return nil, err
 	}
 
-	p := trimBrackets(placeholder)
+	for _, m := range p.Modifiers {
+		if _, ok := filters[m.Name]; !ok {
+			return nil, fmt.Errorf("unknown filter %q", m.Name)
+		}
+	}
 
-	var err error
-	s.value, err = s.fallback(p)
-	if err != nil {
-		return nil, fmt.Errorf("failed to construct fallback password")
+	if p.Wildcard {
+		return &WildcardSecret{
+			scheme:  scheme(p),
+			path:    p.Path,
+			version: p.Version,
+			filters: p.Modifiers,
+		}, nil
 	}
 
-	split := strings.Split(p, "!")
-	fallbackSplit := strings.Split(split[1], ":")
-	s.path, s.key = split[0], fallbackSplit[0]
+	s := &VaultSecret{
+		scheme:  scheme(p),
+		path:    p.Path,
+		key:     p.Key,
+		version: p.Version,
+		value:   p.Fallback,
+		filters: p.Modifiers,
+	}
+	if p.Selector != "" {
+		// Already validated by ParsePlaceholder
+		s.sel, _ = parseSelector(p.Selector)
+	}
 
 	if s.key == "data" {
 		logrus.Warnf("A secret called %q can confuse things, as it's the name of the data "+
@@ -54,49 +87,40 @@ func NewSecret(placeholder string) (Secret, error) {
 	return s, nil
 }
 
-func trimBrackets(placeholder string) string {
-	placeholder = strings.TrimSpace(placeholder)
-	placeholder = strings.TrimLeft(placeholder, "{")
-	placeholder = strings.TrimRight(placeholder, "}")
-	placeholder = strings.TrimSpace(placeholder)
-	return placeholder
+// scheme - the scheme of the backend a placeholder is read from
+func scheme(p *Placeholder) string {
+	if p.Scheme == "" {
+		return backend.DefaultScheme
+	}
+	return p.Scheme
 }
 
 // Retrieve - retries secret from Vault or falls back to default
-func (s *VaultSecret) Retrieve(client vault.Vault) error {
-	secret, err := client.Read(s.path)
-
+func (s *VaultSecret) Retrieve(ctx context.Context, b backend.Backend) error {
+	data, err := readDocument(ctx, b, s.scheme, s.path, s.version)
 	if err != nil {
-		return fmt.Errorf("failed to fetch secret '%s' from Vault: %s", s.path, err)
+		return err
 	}
 
-	if secret == nil {
-		return fmt.Errorf("failed to fetch secret '%s' from Vault, secret was nil", s.path)
+	if x, ok := data[s.key]; ok {
+		logrus.Debugf("Setting value of %s", s.key)
+		return s.setRetrieved(x)
 	}
 
-	if secret.Data == nil {
-		return fmt.Errorf("failed to fetch secret '%s' from Vault, secret.Data was nil", s.path)
+	if s.sel != nil {
+		return fmt.Errorf("secret data for path %s does not contain key %s (a key containing `#` "+
+			"must be quoted, e.g. `!\"pass#word\"`)", s.path, s.key)
 	}
+	return fmt.Errorf("secret data for path %s does not contain key %s", s.path, s.key)
+}
 
-	// Could do with some more sanity-checking here
-	if val, ok := secret.Data["data"]; ok { // KV API v2
-		// Let's not make any KV API v1 secrets called "data", OK?
-		v, ok := val.(map[string]interface{})
-		if !ok {
-			return fmt.Errorf("could not parse KV v2 secret data: %v", val)
-		}
-		if x, ok := v[s.key]; ok {
-			logrus.Debugf("Setting value of %s (KV API v2)", s.key)
-			s.SetValue(x.(string))
-			return nil
-		}
-	} else if val, ok := secret.Data[s.key]; ok { // KV API v1
-		logrus.Debugf("Setting value of %s (KV API v1)", s.key)
-		s.SetValue(val.(string))
-		return nil
+// readDocument - read the document at path from a backend, at the given version if not 0
+func readDocument(ctx context.Context, b backend.Backend, scheme string, path string, version int) (map[string]interface{}, error) {
+	if version > 0 && !b.Capabilities().Versions {
+		return nil, fmt.Errorf("failed to fetch secret '%s': %s", path,
+			&backend.ErrUnsupported{Scheme: scheme, Operation: "versions"})
 	}
-
-	return fmt.Errorf("secret data for path %s does not contain key %s", s.path, s.key)
+	return b.ReadDocument(ctx, path, version)
 }
 
 // Key - Key in a key:value pair
@@ -104,6 +128,11 @@ func (s VaultSecret) Key() string {
 	return s.key
 }
 
+// Scheme - the scheme of the backend the secret is read from
+func (s VaultSecret) Scheme() string {
+	return s.scheme
+}
+
 // Path - the path to the secret
 func (s VaultSecret) Path() string {
 	return s.path
@@ -114,18 +143,84 @@ func (s VaultSecret) Value() string {
 	return s.value
 }
 
+// TypedValue - the value as retrieved from Vault, which may not be a string
+func (s VaultSecret) TypedValue() interface{} {
+	if s.typed != nil {
+		return s.typed
+	}
+	return s.value
+}
+
 // SetValue - set the value of this secret
 func (s *VaultSecret) SetValue(val string) {
 	s.value = val
+	s.typed = nil
+}
+
+// ApplyFilters - apply the secret's filters to its value, whether retrieved or the fallback
+// If a filter fails the value is cleared, so the unfiltered value is never rendered.
+func (s *VaultSecret) ApplyFilters() error {
+	if len(s.filters) == 0 || s.value == "" {
+		return nil
+	}
+	val, err := applyFilters(s.value, s.filters)
+	if err != nil {
+		s.SetValue("")
+		return err
+	}
+	s.SetValue(val)
+	return nil
 }
 
-// Fallback secret in case the secret retrieval fails
-func (s *VaultSecret) fallback(placeholder string) (value string, err error) {
-	if strings.Contains(placeholder, ":") {
-		tempSplit := strings.Split(placeholder, ":")
+// setRetrieved - set the value of this secret from a value retrieved from Vault, which may be of
+// any type that can be decoded from JSON. If the secret has a selector, it is applied first.
+func (s *VaultSecret) setRetrieved(val interface{}) error {
+	if s.sel != nil {
+		selected, err := s.sel.apply(val)
+		if err != nil {
+			return fmt.Errorf("failed selecting from %s in %s: %s", s.key, s.path, err)
+		}
+		val = selected
+	}
 
-		return tempSplit[1], nil
+	str, err := stringValue(val)
+	if err != nil {
+		return fmt.Errorf("value of %s in %s can't be rendered: %s", s.key, s.path, err)
 	}
+	s.value = str
+	s.typed = val
+	return nil
+}
 
-	return "", nil
+// stringValue - the canonical string form of a value: scalars as they would be written in JSON
+// (without quotes for strings) and maps and lists as JSON
+func stringValue(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", fmt.Errorf("%v is not a representable number", v)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case nil:
+		return "", fmt.Errorf("value is null")
+	case map[string]interface{}, []interface{}:
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(b.String(), "\n"), nil
+	}
+	return "", fmt.Errorf("unsupported type %T", val)
 }
@@ -1,37 +1,108 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync"
 
+	"github.com/al4/talebearer/backend"
 	"github.com/al4/talebearer/vault"
 )
 
 // SecretResolver - Doc TODO
 type SecretResolver struct {
-	client vault.Vault
-	secret func(string) (Secret, error)
+	client      vault.Vault
+	backends    map[string]backend.Backend // Keyed by scheme, in addition to client
+	secret      func(string) (Secret, error)
+	concurrency int // Documents read at once, DefaultConcurrency if 0
 }
 
-// NewSecretResolver - create a new SecretResolver
+// DefaultConcurrency - the number of documents read at once, unless set with SetConcurrency
+const DefaultConcurrency = 8
+
+// NewSecretResolver - create a new SecretResolver, which reads secrets from the Vault client
+// and any backends registered with backend.Register
 func NewSecretResolver(client vault.Vault, secretFactory func(string) (Secret, error)) *SecretResolver {
 	return &SecretResolver{
-		client: client,
-		secret: secretFactory,
+		client:   client,
+		backends: backend.Registered(),
+		secret:   secretFactory,
+	}
+}
+
+// RegisterBackend - read secrets with the given scheme from b, for this resolver only
+func (m *SecretResolver) RegisterBackend(scheme string, b backend.Backend) {
+	if m.backends == nil {
+		m.backends = make(map[string]backend.Backend)
 	}
+	m.backends[scheme] = b
+}
+
+// backend - the backend for a scheme, or nil if there is none
+func (m *SecretResolver) backend(scheme string) backend.Backend {
+	if b, ok := m.backends[scheme]; ok {
+		return b
+	}
+	if scheme == backend.DefaultScheme && m.client != nil {
+		return m.client
+	}
+	return nil
 }
 
 // Resolve - resolve secrets for the given placeholders (strings)
-func (m *SecretResolver) Resolve(placeholders []string) (map[string]Secret, error) {
+// Secrets are grouped by the document they are read from, and each document is read once, with
+// up to the resolver's concurrency documents read at a time. Errors are reported in the order of
+// the placeholders. Once ctx is done no more documents are read, and its error is returned.
+func (m *SecretResolver) Resolve(ctx context.Context, placeholders []string) (map[string]Secret, error) {
 
 	secrets, err := m.secrets(placeholders)
 	if err != nil {
 		return nil, err
 	}
 
+	unique, groups := groupByDocument(placeholders, secrets)
+	errs := make([]error, len(unique))
+
+	workers := m.concurrency
+	if workers < 1 {
+		workers = DefaultConcurrency
+	}
+	if workers > len(groups) {
+		workers = len(groups)
+	}
+
+	jobs := make(chan []int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range jobs {
+				cache := &documentCache{}
+				for _, i := range group {
+					if ctx.Err() != nil {
+						break
+					}
+					s := secrets[unique[i]]
+					cache.Backend = m.backend(s.Scheme())
+					errs[i] = s.Retrieve(ctx, cache)
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		jobs <- group
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return secrets, fmt.Errorf("stopped resolving secrets: %s", err)
+	}
+
 	var errStrings []string
-	for _, s := range secrets {
-		err = s.Retrieve(m.client)
+	for _, err := range errs {
 		if err != nil {
 			errStrings = append(errStrings, fmt.Sprintf("\"%s\"", err.Error()))
 		}
@@ -44,13 +115,81 @@ func (m *SecretResolver) Resolve(placeholders []string) (map[string]Secret, erro
 	return secrets, err
 }
 
+// SetConcurrency - set the number of documents which are read at once
+func (m *SecretResolver) SetConcurrency(n int) {
+	m.concurrency = n
+}
+
+// groupByDocument - the distinct placeholders in the order they first appear, and their indices
+// grouped by the document they are read from, in the same order
+func groupByDocument(placeholders []string, secrets map[string]Secret) ([]string, [][]int) {
+	type document struct{ scheme, path string }
+
+	var unique []string
+	var groups [][]int
+	seen := make(map[string]bool)
+	groupIndex := make(map[document]int)
+	for _, p := range placeholders {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		unique = append(unique, p)
+
+		doc := document{scheme: secrets[p].Scheme(), path: secrets[p].Path()}
+		g, ok := groupIndex[doc]
+		if !ok {
+			g = len(groups)
+			groupIndex[doc] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], len(unique)-1)
+	}
+	return unique, groups
+}
+
+// documentCache - a Backend which reads each document from the Backend it wraps only once
+// It is used by one goroutine, for secrets which are read from the same document.
+type documentCache struct {
+	backend.Backend
+	docs map[documentVersion]cachedDocument
+}
+
+type documentVersion struct {
+	path    string
+	version int
+}
+
+type cachedDocument struct {
+	fields map[string]interface{}
+	err    error
+}
+
+// ReadDocument - read the document from the wrapped Backend, unless it has already been read
+func (c *documentCache) ReadDocument(ctx context.Context, path string, version int) (map[string]interface{}, error) {
+	key := documentVersion{path: path, version: version}
+	if doc, ok := c.docs[key]; ok {
+		return doc.fields, doc.err
+	}
+	fields, err := c.Backend.ReadDocument(ctx, path, version)
+	if c.docs == nil {
+		c.docs = make(map[documentVersion]cachedDocument)
+	}
+	c.docs[key] = cachedDocument{fields: fields, err: err}
+	return fields, err
+}
+
 // secrets - Construct a map of secrets from placeholders
 func (m *SecretResolver) secrets(placeholders []string) (map[string]Secret, error) {
 	secrets := make(map[string]Secret)
 	for _, placeholder := range placeholders {
 		s, err := m.secret(placeholder)
 		if err != nil {
-			return nil, fmt.Errorf("could not construct secret for %s", placeholder)
+			return nil, fmt.Errorf("could not construct secret for %s: %s", placeholder, err)
+		}
+		if m.backend(s.Scheme()) == nil {
+			return nil, fmt.Errorf("could not construct secret for %s: unknown scheme %q, "+
+				"valid schemes are %v", placeholder, s.Scheme(), m.schemes())
 		}
 		secrets[placeholder] = s
 	}
@@ -58,3 +197,15 @@ func (m *SecretResolver) secrets(placeholders []string) (map[string]Secret, erro
 	return secrets, nil
 
 }
+
+// schemes - the schemes this resolver can read secrets from
+func (m *SecretResolver) schemes() []string {
+	backends := make(map[string]backend.Backend, len(m.backends)+1)
+	for scheme, b := range m.backends {
+		backends[scheme] = b
+	}
+	if m.client != nil {
+		backends[backend.DefaultScheme] = m.client
+	}
+	return backend.Schemes(backends)
+}
@@ -1,8 +1,16 @@
 package internal
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/al4/talebearer/backend"
 	"github.com/al4/talebearer/vault"
 )
 
@@ -11,11 +19,12 @@ type mockSecret struct {
 	ReturnError  error
 }
 
-func (s *mockSecret) Retrieve(client vault.Vault) error { return s.ReturnError }
-func (s *mockSecret) Value() string                     { return s.ReturnString }
-func (s *mockSecret) Key() string                       { return s.ReturnString }
-func (s *mockSecret) Path() string                      { return s.ReturnString }
-func (s *mockSecret) SetValue(val string)               {}
+func (s *mockSecret) Retrieve(ctx context.Context, b backend.Backend) error { return s.ReturnError }
+func (s *mockSecret) Scheme() string                                        { return backend.DefaultScheme }
+func (s *mockSecret) Value() string                                         { return s.ReturnString }
+func (s *mockSecret) Key() string                                           { return s.ReturnString }
+func (s *mockSecret) Path() string                                          { return s.ReturnString }
+func (s *mockSecret) SetValue(val string)                                   {}
 
 // Ensure the mock satisfies the interface
 var _ Secret = (*mockSecret)(nil)
@@ -37,7 +46,7 @@ func TestResolveSecretsPropertiesFile(t *testing.T) {
 		"secret/example2!key",
 	}
 
-	secrets, err := r.Resolve(placeholders)
+	secrets, err := r.Resolve(context.Background(), placeholders)
 	if err != nil {
 		t.Error(err)
 	}
@@ -47,3 +56,155 @@ func TestResolveSecretsPropertiesFile(t *testing.T) {
 		t.Errorf("expected '%s', got '%s'", "mock", result)
 	}
 }
+
+// mapBackend - a backend which serves documents from a map, without versions
+type mapBackend map[string]map[string]interface{}
+
+func (b mapBackend) ReadDocument(ctx context.Context, path string, version int) (map[string]interface{}, error) {
+	doc, ok := b[path]
+	if !ok {
+		return nil, fmt.Errorf("no document at %s", path)
+	}
+	return doc, nil
+}
+
+func (b mapBackend) ListDocuments(ctx context.Context, path string) ([]string, error) {
+	return nil, &backend.ErrUnsupported{Scheme: "map", Operation: "listing"}
+}
+
+func (b mapBackend) Capabilities() backend.Capabilities {
+	return backend.Capabilities{}
+}
+
+func TestResolve_DispatchesByScheme(t *testing.T) {
+	mockClient := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{"key": "from vault"},
+		},
+	}
+	mockClient.On("Read", "secret/example")
+
+	r := NewSecretResolver(mockClient, NewSecret)
+	r.RegisterBackend("map", mapBackend{
+		"secret/example": {"key": "from map"},
+	})
+
+	secrets, err := r.Resolve(context.Background(), []string{
+		"{{ secret/example!key }}",
+		"{{ vault:secret/example!key }}",
+		"{{ map:secret/example!key }}",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "from vault", secrets["{{ secret/example!key }}"].Value())
+	assert.Equal(t, "from vault", secrets["{{ vault:secret/example!key }}"].Value())
+	assert.Equal(t, "from map", secrets["{{ map:secret/example!key }}"].Value())
+}
+
+func TestResolve_UnknownScheme(t *testing.T) {
+	r := NewSecretResolver(new(vault.MockClient), NewSecret)
+	r.RegisterBackend("map", mapBackend{})
+
+	_, err := r.Resolve(context.Background(), []string{"{{ nope:secret/example!key }}"})
+	assert.EqualError(t, err, `could not construct secret for {{ nope:secret/example!key }}: `+
+		`unknown scheme "nope", valid schemes are [env file map vault]`)
+}
+
+func TestResolve_VersionUnsupported(t *testing.T) {
+	r := NewSecretResolver(new(vault.MockClient), NewSecret)
+	r.RegisterBackend("map", mapBackend{"secret/example": {"key": "value"}})
+
+	_, err := r.Resolve(context.Background(), []string{"{{ map:secret/example!key@2 }}"})
+	assert.EqualError(t, err, `["failed to fetch secret 'secret/example': `+
+		`the map backend does not support versions"]`)
+}
+
+// countingBackend - a backend which counts reads of each document, and the most reads which
+// were in progress at once
+type countingBackend struct {
+	mapBackend
+	mu          sync.Mutex
+	reads       map[string]int
+	inFlight    int
+	maxInFlight int
+}
+
+func (b *countingBackend) ReadDocument(ctx context.Context, path string, version int) (map[string]interface{}, error) {
+	b.mu.Lock()
+	b.reads[path]++
+	b.inFlight++
+	if b.inFlight > b.maxInFlight {
+		b.maxInFlight = b.inFlight
+	}
+	b.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	b.mu.Lock()
+	b.inFlight--
+	b.mu.Unlock()
+	return b.mapBackend.ReadDocument(ctx, path, version)
+}
+
+func TestResolve_ReadsEachDocumentOnce(t *testing.T) {
+	docs := mapBackend{}
+	var placeholders []string
+	for i := 0; i < 10; i++ {
+		path := fmt.Sprintf("secret/%d", i)
+		docs[path] = map[string]interface{}{"a": "1", "b": "2"}
+		placeholders = append(placeholders,
+			fmt.Sprintf("{{ map:%s!a }}", path),
+			fmt.Sprintf("{{ map:%s!b }}", path),
+			fmt.Sprintf("{{ map:%s!* }}", path),
+		)
+	}
+	b := &countingBackend{mapBackend: docs, reads: make(map[string]int)}
+
+	r := NewSecretResolver(new(vault.MockClient), NewSecret)
+	r.RegisterBackend("map", b)
+	r.SetConcurrency(3)
+
+	secrets, err := r.Resolve(context.Background(), placeholders)
+	assert.NoError(t, err)
+	assert.Len(t, secrets, 30)
+	assert.Equal(t, "2", secrets["{{ map:secret/7!b }}"].Value())
+	for path, n := range b.reads {
+		assert.Equal(t, 1, n, path)
+	}
+	assert.Len(t, b.reads, 10)
+	assert.True(t, b.maxInFlight <= 3, "read %d documents at once", b.maxInFlight)
+	assert.True(t, b.maxInFlight > 1, "documents were read one at a time")
+}
+
+func TestResolve_ErrorsInPlaceholderOrder(t *testing.T) {
+	placeholders := []string{
+		"{{ map:secret/z!key }}",
+		"{{ map:secret/a!missing }}",
+		"{{ map:secret/a!key }}",
+		"{{ map:secret/m!key }}",
+		"{{ map:secret/z!key }}",
+	}
+	for i := 0; i < 10; i++ {
+		r := NewSecretResolver(new(vault.MockClient), NewSecret)
+		r.RegisterBackend("map", mapBackend{"secret/a": {"key": "value"}})
+
+		_, err := r.Resolve(context.Background(), placeholders)
+		assert.EqualError(t, err, `["no document at secret/z", `+
+			`"secret data for path secret/a does not contain key missing", `+
+			`"no document at secret/m"]`)
+	}
+}
+
+func TestResolve_Cancelled(t *testing.T) {
+	b := &countingBackend{
+		mapBackend: mapBackend{"secret/a": {"key": "value"}},
+		reads:      make(map[string]int),
+	}
+	r := NewSecretResolver(new(vault.MockClient), NewSecret)
+	r.RegisterBackend("map", b)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := r.Resolve(ctx, []string{"{{ map:secret/a!key }}"})
+	assert.EqualError(t, err, "stopped resolving secrets: context canceled")
+	assert.Empty(t, b.reads)
+}
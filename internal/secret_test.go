@@ -1,7 +1,11 @@
 package internal
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -22,6 +26,18 @@ func TestNewSecret_FetchingFallback(t *testing.T) {
 	}
 }
 
+func TestNewSecret_FallbackContainingColon(t *testing.T) {
+	example := "secret/example!key:jdbc:mysql://localhost"
+
+	s, err := NewSecret(example)
+	if err != nil {
+		t.Error(err)
+	}
+	if s.Value() != "jdbc:mysql://localhost" {
+		t.Errorf("expected value 'jdbc:mysql://localhost', got '%s'", s.Value())
+	}
+}
+
 func TestNewSecret_WithNoFallback(t *testing.T) {
 	example := "secret/example!key"
 
@@ -73,7 +89,7 @@ func TestRetrieve_ExistingSecret(t *testing.T) {
 	}
 	mockClient.On("Read", "secret/example")
 
-	err = s.Retrieve(mockClient)
+	err = s.Retrieve(context.Background(), mockClient)
 	if err != nil {
 		t.Error(err)
 	}
@@ -103,7 +119,7 @@ func TestRetrieve_KvAPIv2(t *testing.T) {
 	}
 	mockClient.On("Read", "secret/example")
 
-	err = s.Retrieve(mockClient)
+	err = s.Retrieve(context.Background(), mockClient)
 	if err != nil {
 		t.Error(err)
 	}
@@ -112,6 +128,31 @@ func TestRetrieve_KvAPIv2(t *testing.T) {
 	}
 }
 
+func TestRetrieve_Version(t *testing.T) {
+	s, err := NewSecret("secret/example!testKey@3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockClient := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"testKey": "oldValue"},
+			},
+		},
+	}
+	mockClient.On("ReadVersion", "secret/example", 3)
+
+	err = s.Retrieve(context.Background(), mockClient)
+	if err != nil {
+		t.Error(err)
+	}
+	mockClient.AssertExpectations(t)
+	if s.Value() != "oldValue" {
+		t.Errorf("s.Value() != 'oldValue' (got %s)", s.Value())
+	}
+}
+
 func TestRetrieve_MissingDocument(t *testing.T) {
 	example := "secret/missing!key"
 
@@ -124,7 +165,7 @@ func TestRetrieve_MissingDocument(t *testing.T) {
 	}
 	mockClient.On("Read", "secret/missing")
 
-	err = s.Retrieve(mockClient)
+	err = s.Retrieve(context.Background(), mockClient)
 	if err == nil {
 		t.Error("expected an error")
 	}
@@ -150,7 +191,7 @@ func TestRetrieve_MissingKeyFromValidDocument(t *testing.T) {
 	}
 	mockClient.On("Read", "secret/example")
 
-	err = s.Retrieve(mockClient)
+	err = s.Retrieve(context.Background(), mockClient)
 	if err == nil {
 		t.Error("expected an error")
 	}
@@ -168,7 +209,7 @@ var trimBracketsTests = []struct {
 	{" {{ foo }} ", "foo"},
 	{"{{foo}}", "foo"},
 	{"{{ foo bar }}", "foo bar"},
-	// {"{{foo}}}", "foo}"}, // currently doesn't handle incorrect number of braces
+	{"{{foo}}}", "foo}"},
 }
 
 func TestTrimBrackets(t *testing.T) {
@@ -179,3 +220,122 @@ func TestTrimBrackets(t *testing.T) {
 		}
 	}
 }
+
+var retrieveTypedTests = []struct {
+	value    interface{}
+	expected string
+}{
+	{"string", "string"},
+	{json.Number("42"), "42"},
+	{json.Number("1.5e3"), "1.5e3"},
+	{true, "true"},
+	{float64(0.1), "0.1"},
+	{float64(1e21), "1000000000000000000000"},
+	{map[string]interface{}{"b": json.Number("1"), "a": "<x>"}, `{"a":"<x>","b":1}`},
+	{[]interface{}{"a", false}, `["a",false]`},
+}
+
+func TestRetrieve_TypedValues(t *testing.T) {
+	for _, tc := range retrieveTypedTests {
+		s, err := NewSecret("secret/example!testKey")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mockClient := &vault.MockClient{
+			ReturnSecret: &vaultApi.Secret{
+				Data: map[string]interface{}{
+					"data": map[string]interface{}{"testKey": tc.value},
+				},
+			},
+		}
+		mockClient.On("Read", "secret/example")
+
+		err = s.Retrieve(context.Background(), mockClient)
+		if err != nil {
+			t.Errorf("Retrieve(%v): unexpected error: %s", tc.value, err)
+			continue
+		}
+		if s.Value() != tc.expected {
+			t.Errorf("Retrieve(%v): expected value '%s', got '%s'", tc.value, tc.expected, s.Value())
+		}
+		if !reflect.DeepEqual(s.(TypedSecret).TypedValue(), tc.value) {
+			t.Errorf("Retrieve(%v): typed value was %v", tc.value, s.(TypedSecret).TypedValue())
+		}
+	}
+}
+
+func TestRetrieve_UnrepresentableValues(t *testing.T) {
+	for _, value := range []interface{}{nil, math.NaN(), struct{}{}} {
+		s, err := NewSecret("secret/example!testKey:fallback")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mockClient := &vault.MockClient{
+			ReturnSecret: &vaultApi.Secret{
+				Data: map[string]interface{}{"testKey": value},
+			},
+		}
+		mockClient.On("Read", "secret/example")
+
+		err = s.Retrieve(context.Background(), mockClient)
+		if err == nil {
+			t.Errorf("Retrieve(%v): expected an error", value)
+			continue
+		}
+		if !strings.Contains(err.Error(), "value of testKey in secret/example can't be rendered") {
+			t.Errorf("Retrieve(%v): unexpected error '%s'", value, err)
+		}
+		if s.Value() != "fallback" {
+			t.Errorf("Retrieve(%v): expected the fallback value to be kept, got '%s'", value, s.Value())
+		}
+	}
+}
+
+func TestRetrieve_WithSelector(t *testing.T) {
+	s, err := NewSecret("{{ secret/gcp!credentials.json#client_email }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockClient := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"credentials.json": `{"client_email": "robot@example.com"}`,
+			},
+		},
+	}
+	mockClient.On("Read", "secret/gcp")
+
+	err = s.Retrieve(context.Background(), mockClient)
+	if err != nil {
+		t.Error(err)
+	}
+	if s.Value() != "robot@example.com" {
+		t.Errorf("expected 'robot@example.com', got '%s'", s.Value())
+	}
+}
+
+func TestRetrieve_KeyContainingHash(t *testing.T) {
+	s, err := NewSecret("{{ secret/app!pass#word }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockClient := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{"pass#word": "hunter2"},
+		},
+	}
+	mockClient.On("Read", "secret/app")
+
+	err = s.Retrieve(context.Background(), mockClient)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	expected := "a key containing `#` must be quoted"
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected error to contain '%s', got '%s'", expected, err)
+	}
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// selector - a path into a nested value, e.g. `client.emails[0]`
+// Keys are separated by `.`, and `[n]` indexes a list. Keys containing `.` or `[` can be given
+// in brackets, e.g. `[my.key]`.
+type selector []selectorStep
+
+type selectorStep struct {
+	key     string
+	index   int // Only used if isIndex
+	isIndex bool
+}
+
+func (s selectorStep) String() string {
+	if s.isIndex {
+		return fmt.Sprintf("[%d]", s.index)
+	}
+	return s.key
+}
+
+// parseSelector - parse a selector expression
+func parseSelector(expr string) (selector, error) {
+	var sel selector
+	i := 0
+	for i < len(expr) {
+		switch {
+		case expr[i] == '[':
+			end := strings.IndexByte(expr[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed `[` in selector %q", expr)
+			}
+			inner := expr[i+1 : i+end]
+			if inner == "" {
+				return nil, fmt.Errorf("empty `[]` in selector %q", expr)
+			}
+			if n, err := strconv.Atoi(inner); err == nil && n >= 0 {
+				sel = append(sel, selectorStep{index: n, isIndex: true})
+			} else {
+				sel = append(sel, selectorStep{key: inner})
+			}
+			i += end + 1
+		case expr[i] == '.' && len(sel) > 0:
+			i++
+			end := strings.IndexAny(expr[i:], ".[")
+			if end < 0 {
+				end = len(expr) - i
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in selector %q", expr)
+			}
+			sel = append(sel, selectorStep{key: expr[i : i+end]})
+			i += end
+		case len(sel) == 0:
+			end := strings.IndexAny(expr, ".[")
+			if end < 0 {
+				end = len(expr)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in selector %q", expr)
+			}
+			sel = append(sel, selectorStep{key: expr[:end]})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %q in selector %q", expr[i], expr)
+		}
+	}
+	return sel, nil
+}
+
+// apply - select from a value; a string is decoded as JSON before selecting from it
+func (sel selector) apply(val interface{}) (interface{}, error) {
+	for n, step := range sel {
+		if s, ok := val.(string); ok {
+			dec := json.NewDecoder(bytes.NewBufferString(s))
+			dec.UseNumber()
+			if err := dec.Decode(&val); err != nil {
+				return nil, fmt.Errorf("%s is not a JSON document", sel.describe(n))
+			}
+		}
+
+		switch v := val.(type) {
+		case map[string]interface{}:
+			if step.isIndex {
+				return nil, fmt.Errorf("%s is an object, not a list", sel.describe(n))
+			}
+			x, ok := v[step.key]
+			if !ok {
+				return nil, fmt.Errorf("%s does not contain key %s", sel.describe(n), step.key)
+			}
+			val = x
+		case []interface{}:
+			if !step.isIndex {
+				return nil, fmt.Errorf("%s is a list, not an object", sel.describe(n))
+			}
+			if step.index >= len(v) {
+				return nil, fmt.Errorf("%s has no index %d", sel.describe(n), step.index)
+			}
+			val = v[step.index]
+		default:
+			return nil, fmt.Errorf("%s is a %T, not an object or list", sel.describe(n), val)
+		}
+	}
+	return val, nil
+}
+
+// describe - a description of the value selected by the first n steps, for errors
+func (sel selector) describe(n int) string {
+	if n == 0 {
+		return "value"
+	}
+	var b strings.Builder
+	for i, step := range sel[:n] {
+		if i > 0 && !step.isIndex {
+			b.WriteString(".")
+		}
+		b.WriteString(step.String())
+	}
+	return b.String()
+}
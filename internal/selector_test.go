@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var parseSelectorTests = []struct {
+	expr     string
+	expected selector
+}{
+	{"a", selector{{key: "a"}}},
+	{"a.b", selector{{key: "a"}, {key: "b"}}},
+	{"a[0].b", selector{{key: "a"}, {index: 0, isIndex: true}, {key: "b"}}},
+	{"[1]", selector{{index: 1, isIndex: true}}},
+	{"[a.b].c", selector{{key: "a.b"}, {key: "c"}}},
+	{"a[-1]", selector{{key: "a"}, {key: "-1"}}},
+}
+
+func TestParseSelector(t *testing.T) {
+	for _, tc := range parseSelectorTests {
+		sel, err := parseSelector(tc.expr)
+		if err != nil {
+			t.Errorf("parseSelector(%s): unexpected error: %s", tc.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(sel, tc.expected) {
+			t.Errorf("parseSelector(%s): expected %+v, got %+v", tc.expr, tc.expected, sel)
+		}
+	}
+}
+
+func TestParseSelector_Errors(t *testing.T) {
+	for _, expr := range []string{".a", "a.", "a..b", "a[0", "a[]", "a[0]b"} {
+		if _, err := parseSelector(expr); err == nil {
+			t.Errorf("parseSelector(%s): expected an error", expr)
+		}
+	}
+}
+
+var credentials = `{
+  "type": "service_account",
+  "client_email": "robot@example.iam.gserviceaccount.com",
+  "scopes": ["read", "write"],
+  "nested": {"port": 5432}
+}`
+
+var applySelectorTests = []struct {
+	expr     string
+	value    interface{}
+	expected interface{}
+	err      string
+}{
+	{"client_email", credentials, "robot@example.iam.gserviceaccount.com", ""},
+	{"scopes[1]", credentials, "write", ""},
+	{"nested.port", credentials, json.Number("5432"), ""},
+	{"nested", credentials, map[string]interface{}{"port": json.Number("5432")}, ""},
+	{"a.b", map[string]interface{}{"a": map[string]interface{}{"b": true}}, true, ""},
+	{"a.b", map[string]interface{}{"a": `{"b": "encoded"}`}, "encoded", ""},
+	{"missing", credentials, nil, "value does not contain key missing"},
+	{"scopes[2]", credentials, nil, "scopes has no index 2"},
+	{"scopes.read", credentials, nil, "scopes is a list, not an object"},
+	{"nested[0]", credentials, nil, "nested is an object, not a list"},
+	{"type.x", credentials, nil, "type is not a JSON document"},
+	{"a", "not json", nil, "value is not a JSON document"},
+}
+
+func TestSelector_Apply(t *testing.T) {
+	for _, tc := range applySelectorTests {
+		sel, err := parseSelector(tc.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := sel.apply(tc.value)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("apply(%s): expected error '%s', got %v", tc.expr, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("apply(%s): unexpected error: %s", tc.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("apply(%s): expected %#v, got %#v", tc.expr, tc.expected, result)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// structuredDocument - a parsed JSON or YAML document
+type structuredDocument interface {
+	// walk - call f with each string value in the document, replacing the value with f's
+	// result, which may be of any type
+	walk(f func(s string) (interface{}, error)) error
+	encode(w io.Writer) error
+}
+
+var structuredParsers = map[string]func(io.Reader) (structuredDocument, error){
+	"json": parseJSONDocument,
+	"yaml": parseYAMLDocument,
+}
+
+// StructuredTemplateFile - a JSON or YAML template which is parsed rather than treated as text,
+// so that placeholders are only found in string values, and a string which is entirely a
+// placeholder can be replaced by a value of another type, such as a number or an object
+type StructuredTemplateFile struct {
+	path   string
+	format string
+	parse  func(io.Reader) (structuredDocument, error)
+}
+
+// NewStructuredTemplateFile - create a structured template of the given format ("json" or
+// "yaml"), or detect the format from the file name if format is empty
+func NewStructuredTemplateFile(filename string, format string) (*StructuredTemplateFile, error) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, fmt.Errorf("file %s does not exist", filename)
+	}
+
+	if format == "" {
+		format = detectFormatName(filename)
+	}
+	format = strings.ToLower(format)
+	parse, ok := structuredParsers[format]
+	if !ok {
+		return nil, fmt.Errorf("structured rendering is only supported for json and yaml, not %s", format)
+	}
+
+	return &StructuredTemplateFile{
+		path:   filename,
+		format: format,
+		parse:  parse,
+	}, nil
+}
+
+// FindPlaceholders - Find the placeholders in the string values of the template file
+func (t *StructuredTemplateFile) FindPlaceholders() (placeholders []string, err error) {
+	doc, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+
+	err = doc.walk(func(s string) (interface{}, error) {
+		for _, seg := range scanLine(s) {
+			if !seg.placeholder {
+				continue
+			}
+			ph, err := ParsePlaceholder(seg.text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid placeholder %s: %s", seg.text, err)
+			}
+			if ph.Wildcard {
+				return nil, fmt.Errorf("invalid placeholder %s: wildcards are not supported in "+
+					"structured templates", seg.text)
+			}
+			placeholders = append(placeholders, seg.text)
+		}
+		return s, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return placeholders, nil
+}
+
+// RenderSecrets - Render the secrets given to a file
+func (t *StructuredTemplateFile) RenderSecrets(secrets map[string]Secret, outputFile string) error {
+	doc, err := t.read()
+	if err != nil {
+		return err
+	}
+
+	err = doc.walk(func(s string) (interface{}, error) {
+		return substitute(s, secrets), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeFile(outputFile, doc.encode)
+}
+
+func (t *StructuredTemplateFile) read() (structuredDocument, error) {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	doc, err := t.parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing %s as %s: %s", t.path, t.format, err)
+	}
+	return doc, nil
+}
+
+// substitute - replace the placeholders in a string value
+// If the string is exactly one placeholder, the secret's typed value is used if it has one.
+func substitute(s string, secrets map[string]Secret) interface{} {
+	segments := scanLine(s)
+	if len(segments) == 1 && segments[0].placeholder {
+		if ts, ok := secrets[s].(TypedSecret); ok {
+			if _, isString := ts.TypedValue().(string); !isString {
+				return ts.TypedValue()
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, seg := range segments {
+		if secret, ok := secrets[seg.text]; ok && seg.placeholder && secret.Value() != "" {
+			b.WriteString(secret.Value())
+		} else {
+			b.WriteString(seg.text)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// structuredSecrets - secrets for the examples/structured.* templates, with typed values
+func structuredSecrets() map[string]Secret {
+	secrets := make(map[string]Secret)
+	secrets["{{ secret/example!password }}"], _ = NewSecret("secret/example!password")
+	secrets["{{ secret/example!password }}"].SetValue(`p"ss: word`)
+	secrets["{{ secret/example!host }}"], _ = NewSecret("secret/example!host:db.example.com")
+
+	port := &VaultSecret{path: "secret/example", key: "port"}
+	_ = port.setRetrieved(json.Number("8080"))
+	secrets["{{ secret/example!port }}"] = port
+
+	options := &VaultSecret{path: "secret/example", key: "options"}
+	_ = options.setRetrieved(map[string]interface{}{"ssl": true, "timeout": json.Number("30")})
+	secrets["{{ secret/example!options }}"] = options
+
+	return secrets
+}
+
+func renderStructured(t *testing.T, filename string) string {
+	tmpfile, err := ioutil.TempFile("", "tempfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	template, err := NewStructuredTemplateFile(filename, "")
+	assert.NoError(t, err)
+	err = template.RenderSecrets(structuredSecrets(), tmpfile.Name())
+	assert.NoError(t, err)
+
+	contents, err := ioutil.ReadFile(tmpfile.Name())
+	assert.NoError(t, err)
+	return string(contents)
+}
+
+func TestStructuredTemplateFile_FindPlaceholders(t *testing.T) {
+	for _, filename := range []string{"../examples/structured.json", "../examples/structured.yaml"} {
+		template, err := NewStructuredTemplateFile(filename, "")
+		assert.NoError(t, err)
+
+		placeholders, err := template.FindPlaceholders()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			"{{ secret/example!password }}",
+			"{{ secret/example!port }}",
+			"{{ secret/example!host }}",
+			"{{ secret/example!options }}",
+			"{{ secret/example!host }}",
+		}, placeholders, filename)
+	}
+}
+
+func TestStructuredTemplateFile_RenderJSON(t *testing.T) {
+	expected := `{
+    "name": "example",
+    "{{ secret/example!key }}": "keys are never substituted",
+    "password": "p\"ss: word",
+    "port": 8080,
+    "database": {
+        "url": "jdbc:mysql://db.example.com/db",
+        "options": {
+            "ssl": true,
+            "timeout": 30
+        }
+    },
+    "hosts": [
+        "a",
+        "db.example.com"
+    ]
+}
+`
+	assert.Equal(t, expected, renderStructured(t, "../examples/structured.json"))
+}
+
+func TestStructuredTemplateFile_RenderYAML(t *testing.T) {
+	expected := `# Application settings
+name: example
+password: "p\"ss: word" # from Vault
+port: 8080
+database:
+  url: jdbc:mysql://db.example.com/db
+  options:
+    ssl: true
+    timeout: 30
+hosts:
+  - a
+  - "db.example.com"
+`
+	assert.Equal(t, expected, renderStructured(t, "../examples/structured.yaml"))
+}
+
+func TestNewStructuredTemplateFile_UnsupportedFormat(t *testing.T) {
+	_, err := NewStructuredTemplateFile("../examples/example.properties", "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "only supported for json and yaml, not properties")
+}
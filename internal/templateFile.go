@@ -2,12 +2,10 @@ package internal
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"regexp"
-	"strings"
-
-	log "github.com/sirupsen/logrus"
+	"path/filepath"
 )
 
 // Template - Doc TODO
@@ -18,8 +16,9 @@ type Template interface {
 
 // TemplateFile - Doc TODO
 type TemplateFile struct {
-	path    string
-	matcher *regexp.Regexp
+	path     string
+	format   Format
+	wildcard WildcardStyle
 }
 
 // NewTemplateFile - Doc TODO
@@ -28,42 +27,78 @@ func NewTemplateFile(filename string) (t *TemplateFile, err error) {
 		return nil, fmt.Errorf("file %s does not exist", filename)
 	}
 	return &TemplateFile{
-		path:    filename,
-		matcher: regexp.MustCompile(`{{\s*([^ }]*)?\s*}}`),
+		path:   filename,
+		format: DetectFormat(filename),
 	}, nil
 }
 
-// FindPlaceholders - Find the placeholders in a given string
-func (t *TemplateFile) FindPlaceholders() (placeholders []string, err error) {
+// SetFormat - override the format detected from the file name
+func (t *TemplateFile) SetFormat(format Format) {
+	t.format = format
+}
+
+// SetWildcardStyle - set how wildcard placeholders are expanded
+func (t *TemplateFile) SetWildcardStyle(style WildcardStyle) {
+	t.wildcard = style
+}
 
-	contents, err := ioutil.ReadFile(t.path)
+// FindPlaceholders - Find the placeholders in the template file
+func (t *TemplateFile) FindPlaceholders() (placeholders []string, err error) {
+	f, err := os.Open(t.path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	return t.matcher.FindAllString(string(contents), -1), nil
+	return FindPlaceholders(f)
 }
 
 // RenderSecrets - Render the secrets given to a file
 func (t *TemplateFile) RenderSecrets(secrets map[string]Secret, outputFile string) (err error) {
-	contents, err := ioutil.ReadFile(t.path)
+	in, err := os.Open(t.path)
 	if err != nil {
 		return err
 	}
+	defer in.Close()
 
-	newContents := string(contents)
-	for p, s := range secrets {
-		log.Infof("Replacing %s\n", s.Path())
-		if s.Value() == "" {
-			log.Warnf("Not replacing %s, empty string value", p)
-			continue
-		}
-		newContents = strings.Replace(newContents, p, s.Value(), -1)
+	return writeFile(outputFile, func(w io.Writer) error {
+		return Render(in, w, secrets, t.format, t.wildcard)
+	})
+}
+
+// writeFile - write to a temporary file which replaces outputFile once complete, so it is safe
+// for outputFile to be the template being rendered
+func writeFile(outputFile string, write func(io.Writer) error) (err error) {
+	out, err := ioutil.TempFile(filepath.Dir(outputFile), "."+filepath.Base(outputFile))
+	if err != nil {
+		return fmt.Errorf("failed writing to file '%s': %s", outputFile, err)
 	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(out.Name())
+		}
+	}()
 
-	err = ioutil.WriteFile(outputFile, []byte(newContents), 0644)
+	err = write(out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(out.Name(), outputMode(outputFile))
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), outputFile)
+	}
 	if err != nil {
 		return fmt.Errorf("failed writing to file '%s': %s", outputFile, err)
 	}
 	return nil
 }
+
+// outputMode - the permissions of an existing output file, to be kept when it's replaced
+func outputMode(outputFile string) os.FileMode {
+	if info, err := os.Stat(outputFile); err == nil {
+		return info.Mode().Perm()
+	}
+	return 0644
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"encoding/json"
 	"os"
 	"testing"
 
@@ -61,6 +62,24 @@ func (suite *TemplateFileTestSuite) TestFindPlaceholdersInJsonFile() {
 	assert.NotContains(suite.T(), placeholders, "{{ secret/invalid!invalid }")
 }
 
+func (suite *TemplateFileTestSuite) TestFindPlaceholdersWithSyntaxError() {
+	tmpfile, err := ioutil.TempFile("", "tempfile")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	_, err = tmpfile.WriteString("public=blah\nsecret={{ secret/example!foo!bar }}\n")
+	assert.NoError(suite.T(), err)
+
+	template, err := NewTemplateFile(tmpfile.Name())
+	assert.NoError(suite.T(), err)
+
+	placeholders, err := template.FindPlaceholders()
+	assert.Error(suite.T(), err)
+	assert.Nil(suite.T(), placeholders)
+	assert.Contains(suite.T(), err.Error(), "line 2, column 29: unexpected second `!` in key")
+}
+
 func (suite *TemplateFileTestSuite) TestRenderSecretsInPropertiesFile() {
 	tmpfile, err := ioutil.TempFile("", "tempfile")
 	if err != nil {
@@ -90,6 +109,29 @@ func (suite *TemplateFileTestSuite) TestRenderSecretsInPropertiesFile() {
 	assert.Contains(suite.T(), string(contents), "baz=boz")
 }
 
+func (suite *TemplateFileTestSuite) TestRenderSecretsInJsonFile() {
+	tmpfile, err := ioutil.TempFile("", "tempfile")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	secrets := make(map[string]Secret)
+	secrets["{{ secret/example!password_key }}"], _ = NewSecret("secret/example!password_key")
+	secrets["{{ secret/example!password_key }}"].SetValue(`p"ss\word`)
+
+	template, err := NewTemplateFile("../examples/example.json")
+	assert.NoError(suite.T(), err)
+	err = template.RenderSecrets(secrets, tmpfile.Name())
+	assert.NoError(suite.T(), err)
+
+	contents, err := ioutil.ReadFile(tmpfile.Name())
+	assert.NoError(suite.T(), err)
+	var rendered map[string]string
+	assert.NoError(suite.T(), json.Unmarshal(contents, &rendered))
+	assert.Equal(suite.T(), `p"ss\word`, rendered["baz"])
+}
+
 func (suite *TemplateFileTestSuite) TestRenderSecretsInGenericFile() {
 	tmpfile, err := ioutil.TempFile("", "tempfile")
 	if err != nil {
@@ -119,6 +161,35 @@ func (suite *TemplateFileTestSuite) TestRenderSecretsInGenericFile() {
 	assert.Contains(suite.T(), string(contents), "such as {{ secret/invalid!invalid } should not be resolved")
 }
 
+func (suite *TemplateFileTestSuite) TestRenderSecretsWithEscapes() {
+	tmpfile, err := ioutil.TempFile("", "tempfile")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	template, err := NewTemplateFile("../examples/escaped.yaml")
+	assert.NoError(suite.T(), err)
+
+	placeholders, err := template.FindPlaceholders()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"{{ secret/example!foo }}", "{{ secret/example!two }}"}, placeholders)
+
+	secrets := make(map[string]Secret)
+	secrets["{{ secret/example!foo }}"], _ = NewSecret("secret/example!foo:fallback1")
+	secrets["{{ secret/example!two }}"], _ = NewSecret("secret/example!two:fallback2")
+
+	err = template.RenderSecrets(secrets, tmpfile.Name())
+	assert.NoError(suite.T(), err)
+
+	contents, err := ioutil.ReadFile(tmpfile.Name())
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), string(contents), "password: fallback1\n")
+	assert.Contains(suite.T(), string(contents), "annotation: {{ .Release.Name }}\n")
+	assert.Contains(suite.T(), string(contents), `checksum: {{ include "config" . | sha256sum }}`)
+	assert.Contains(suite.T(), string(contents), "other: fallback2\n")
+}
+
 func (suite *TemplateFileTestSuite) TestRenderSecretsInPemFile() {
 	tmpfile, err := ioutil.TempFile("", "tempfile")
 	if err != nil {
@@ -145,6 +216,29 @@ func (suite *TemplateFileTestSuite) TestRenderSecretsInPemFile() {
 	assert.Equal(suite.T(), secrets["{{ secret/rsa_key!pem }}"].Value(), string(contents))
 }
 
+func (suite *TemplateFileTestSuite) TestRenderSecretsInPlace() {
+	tmpfile, err := ioutil.TempFile("", "tempfile")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	_, err = tmpfile.WriteString("secret={{ secret/example!foo }}\n")
+	assert.NoError(suite.T(), err)
+	assert.NoError(suite.T(), tmpfile.Close())
+
+	secrets := make(map[string]Secret)
+	secrets["{{ secret/example!foo }}"], _ = NewSecret("secret/example!foo:fallback1")
+
+	template, err := NewTemplateFile(tmpfile.Name())
+	assert.NoError(suite.T(), err)
+	err = template.RenderSecrets(secrets, tmpfile.Name())
+	assert.NoError(suite.T(), err)
+
+	contents, err := ioutil.ReadFile(tmpfile.Name())
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "secret=fallback1\n", string(contents))
+}
+
 func (suite *TemplateFileTestSuite) TestRenderSecretsInFileWithNoSecrets() {
 	tmpfile, err := ioutil.TempFile("", "tempfile")
 	if err != nil {
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// directiveDisable - lines following one containing this are not scanned for placeholders
+	directiveDisable = "talebearer:disable"
+	// directiveEnable - resumes scanning for placeholders after a directiveDisable
+	directiveEnable = "talebearer:enable"
+)
+
+// segment - a piece of a template line, either literal text or a placeholder
+type segment struct {
+	text        string
+	placeholder bool
+	column      int // Column of the segment within the line, counting from 1
+}
+
+// templateScanner - splits template lines into segments, tracking directives across lines
+type templateScanner struct {
+	disabled bool
+}
+
+// scan - split the next line of the template into segments
+// The directive lines themselves are always passed through unchanged.
+func (s *templateScanner) scan(line string) []segment {
+	switch {
+	case s.disabled:
+		s.disabled = !strings.Contains(line, directiveEnable)
+		return []segment{{text: line, column: 1}}
+	case strings.Contains(line, directiveDisable):
+		s.disabled = true
+		return []segment{{text: line, column: 1}}
+	}
+	return scanLine(line)
+}
+
+// scanLine - split a line of a template into literal text and placeholders
+// A `{{` with no matching `}}` on the same line is literal text, as is anything between braces
+// which isn't shaped like a placeholder, and `\{{` is rendered as a literal `{{`.
+func scanLine(line string) []segment {
+	var segments []segment
+	textStart := 0
+	i := 0
+	for {
+		open := strings.Index(line[i:], "{{")
+		if open < 0 {
+			break
+		}
+		open += i
+
+		if open > 0 && line[open-1] == '\\' {
+			// Drop the backslash, the braces become the start of the next text segment
+			segments = appendText(segments, line, textStart, open-1)
+			textStart, i = open, open+2
+			continue
+		}
+
+		end := closingBraces(line, open+2)
+		if end < 0 {
+			i = open + 2
+			continue
+		}
+		if !isPlaceholder(line[open:end]) {
+			i = end
+			continue
+		}
+
+		segments = appendText(segments, line, textStart, open)
+		segments = append(segments, segment{
+			text:        line[open:end],
+			placeholder: true,
+			column:      column(line, open),
+		})
+		textStart, i = end, end
+	}
+
+	return appendText(segments, line, textStart, len(line))
+}
+
+func appendText(segments []segment, line string, start, end int) []segment {
+	if start >= end {
+		return segments
+	}
+	return append(segments, segment{
+		text:   line[start:end],
+		column: column(line, start),
+	})
+}
+
+// closingBraces - find the end of the `}}` closing a placeholder, skipping over quoted strings
+// Returns -1 if the placeholder is not closed before the end of the line or another `{{`.
+func closingBraces(line string, start int) int {
+	inQuote := false
+	for i := start; i < len(line); i++ {
+		switch {
+		case inQuote && line[i] == '\\':
+			i++
+		case line[i] == '"':
+			inQuote = !inQuote
+		case !inQuote && strings.HasPrefix(line[i:], "}}"):
+			return i + 2
+		case !inQuote && strings.HasPrefix(line[i:], "{{"):
+			return -1
+		}
+	}
+	return -1
+}
+
+func column(line string, offset int) int {
+	return utf8.RuneCountInString(line[:offset]) + 1
+}
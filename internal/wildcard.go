@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/al4/talebearer/backend"
+)
+
+// Key cases for WildcardStyle
+const (
+	KeyCaseUnchanged = ""
+	KeyCaseUpper     = "upper"
+	KeyCaseLower     = "lower"
+)
+
+// WildcardStyle - how the fields of a wildcard placeholder are written, one per line
+type WildcardStyle struct {
+	Prefix    string // Prepended to each key
+	Separator string // Between each key and its value, `=` if empty
+	KeyCase   string // One of the KeyCase constants
+}
+
+// NewWildcardStyle - create a WildcardStyle, checking the key case is valid
+func NewWildcardStyle(prefix, separator, keyCase string) (WildcardStyle, error) {
+	switch keyCase {
+	case KeyCaseUnchanged, KeyCaseUpper, KeyCaseLower:
+	default:
+		return WildcardStyle{}, fmt.Errorf("unknown key case %q, valid key cases are %q and %q",
+			keyCase, KeyCaseUpper, KeyCaseLower)
+	}
+	return WildcardStyle{
+		Prefix:    prefix,
+		Separator: separator,
+		KeyCase:   keyCase,
+	}, nil
+}
+
+// key - the key written for a field
+func (ws WildcardStyle) key(field string) string {
+	switch ws.KeyCase {
+	case KeyCaseUpper:
+		field = strings.ToUpper(field)
+	case KeyCaseLower:
+		field = strings.ToLower(field)
+	}
+	return ws.Prefix + field
+}
+
+func (ws WildcardStyle) separator() string {
+	if ws.Separator == "" {
+		return "="
+	}
+	return ws.Separator
+}
+
+// keyEscaper - a Format which also escapes keys, for formats where keys have their own syntax
+type keyEscaper interface {
+	EscapeKey(key string) string
+}
+
+// DocumentSecret - a Secret standing for every field of a document, rather than a single value
+type DocumentSecret interface {
+	Secret
+	Fields() map[string]string
+}
+
+// WildcardSecret - every field of a document, from a placeholder such as
+// `{{ secret/app!* }}`
+type WildcardSecret struct {
+	scheme  string            // Scheme of the backend the secret is read from
+	path    string            // Document path inside the backend
+	version int               // Version of a KV v2 document, 0 for the latest
+	filters []Modifier        // Filters to apply to each value once retrieved
+	fields  map[string]string // Field values, nil until retrieved
+}
+
+// Retrieve - retrieve every field of the document from its backend
+func (s *WildcardSecret) Retrieve(ctx context.Context, b backend.Backend) error {
+	data, err := readDocument(ctx, b, s.scheme, s.path, s.version)
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]string, len(data))
+	for key, val := range data {
+		str, err := stringValue(val)
+		if err != nil {
+			return fmt.Errorf("value of %s in %s can't be rendered: %s", key, s.path, err)
+		}
+		fields[key] = str
+	}
+	s.fields = fields
+	return nil
+}
+
+// Fields - the values of every field in the document, keyed by field name
+func (s WildcardSecret) Fields() map[string]string {
+	return s.fields
+}
+
+// Key - always `*`, as the secret has every key in the document
+func (s WildcardSecret) Key() string {
+	return "*"
+}
+
+// Scheme - the scheme of the backend the secret is read from
+func (s WildcardSecret) Scheme() string {
+	return s.scheme
+}
+
+// Path - the path to the secret
+func (s WildcardSecret) Path() string {
+	return s.path
+}
+
+// Value - always empty, as a wildcard has no single value; see Fields
+func (s WildcardSecret) Value() string {
+	return ""
+}
+
+// SetValue - has no effect, as a wildcard has no single value
+func (s *WildcardSecret) SetValue(val string) {}
+
+// ApplyFilters - apply the secret's filters to the value of each field
+// If a filter fails for any field the fields are cleared, so no unfiltered value is rendered.
+func (s *WildcardSecret) ApplyFilters() error {
+	if len(s.filters) == 0 {
+		return nil
+	}
+	// In order, so that the error names the same field every time
+	keys := make([]string, 0, len(s.fields))
+	for key := range s.fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		val := s.fields[key]
+		if val == "" {
+			continue
+		}
+		filtered, err := applyFilters(val, s.filters)
+		if err != nil {
+			s.fields = nil
+			return fmt.Errorf("field %s: %s", key, err)
+		}
+		s.fields[key] = filtered
+	}
+	return nil
+}
+
+// renderFields - write one line per field, sorted by the key written, each starting with indent
+// and ending with lineEnding
+func renderFields(b *strings.Builder, fields map[string]string, indent, lineEnding string,
+	style WildcardStyle, format Format) {
+	type line struct{ field, key string }
+	lines := make([]line, 0, len(fields))
+	for field := range fields {
+		lines = append(lines, line{field: field, key: style.key(field)})
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].key != lines[j].key {
+			return lines[i].key < lines[j].key
+		}
+		return lines[i].field < lines[j].field
+	})
+
+	for i, l := range lines {
+		var prefix strings.Builder
+		prefix.WriteString(indent)
+		if ke, ok := format.(keyEscaper); ok {
+			prefix.WriteString(ke.EscapeKey(l.key))
+		} else {
+			prefix.WriteString(l.key)
+		}
+		prefix.WriteString(style.separator())
+
+		b.WriteString(prefix.String())
+		b.WriteString(format.Escape(prefix.String(), fields[l.field]))
+		if i < len(lines)-1 {
+			b.WriteString("\n")
+		} else {
+			b.WriteString(lineEnding)
+		}
+	}
+}
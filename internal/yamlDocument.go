@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlDocument - a stream of YAML documents, which keeps comments, key order and scalar styles
+type yamlDocument struct {
+	docs   []*yaml.Node
+	indent int
+}
+
+func parseYAMLDocument(r io.Reader) (structuredDocument, error) {
+	d := &yamlDocument{indent: 2}
+	dec := yaml.NewDecoder(r)
+	for {
+		var n yaml.Node
+		err := dec.Decode(&n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.docs = append(d.docs, &n)
+	}
+
+	for _, n := range d.docs {
+		if indent := yamlIndent(n); indent > 0 {
+			d.indent = indent
+			break
+		}
+	}
+	return d, nil
+}
+
+// yamlIndent - the indentation of the first nested mapping in the document, or 0 if there is none
+func yamlIndent(n *yaml.Node) int {
+	if n.Kind == yaml.MappingNode {
+		for i := 1; i < len(n.Content); i += 2 {
+			child := n.Content[i]
+			if child.Kind == yaml.MappingNode && len(child.Content) > 0 && child.Style&yaml.FlowStyle == 0 {
+				return child.Content[0].Column - n.Content[i-1].Column
+			}
+		}
+	}
+	for _, child := range n.Content {
+		if indent := yamlIndent(child); indent > 0 {
+			return indent
+		}
+	}
+	return 0
+}
+
+func (d *yamlDocument) walk(f func(s string) (interface{}, error)) error {
+	for _, n := range d.docs {
+		if err := walkYAML(n, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkYAML(n *yaml.Node, f func(s string) (interface{}, error)) error {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range n.Content {
+			if err := walkYAML(child, f); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		// Only values, never keys
+		for i := 1; i < len(n.Content); i += 2 {
+			if err := walkYAML(n.Content[i], f); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if n.ShortTag() != "!!str" {
+			return nil
+		}
+		v, err := f(n.Value)
+		if err != nil {
+			return err
+		}
+		if s, ok := v.(string); ok {
+			n.Value = s
+			return nil
+		}
+		return replaceYAMLNode(n, v)
+	}
+	return nil
+}
+
+// replaceYAMLNode - replace a scalar with an encoded value of any type, keeping its comments
+func replaceYAMLNode(n *yaml.Node, v interface{}) error {
+	head, line, foot := n.HeadComment, n.LineComment, n.FootComment
+	if err := n.Encode(yamlValue(v)); err != nil {
+		return err
+	}
+	n.HeadComment, n.LineComment, n.FootComment = head, line, foot
+	return nil
+}
+
+// yamlValue - convert json.Number, as returned by the Vault API, into a value yaml can encode
+func yamlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = yamlValue(value)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, value := range v {
+			s[i] = yamlValue(value)
+		}
+		return s
+	}
+	return v
+}
+
+func (d *yamlDocument) encode(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(d.indent)
+	for _, n := range d.docs {
+		if err := enc.Encode(n); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/al4/talebearer/backend"
+	"github.com/al4/talebearer/internal"
+	"github.com/al4/talebearer/vault"
+)
+
+const policyUsage = `Usage: talebearer policy [flags] <template>...
+
+Print a Vault policy which can read exactly the secrets the placeholders in the templates read,
+and nothing else, e.g.
+
+  path "secret/data/app" {
+    capabilities = ["read"]
+  }
+
+Secrets on KV v2 mounts are read from their data/ path, so the mount of each secret is looked up
+in Vault, unless -mount-versions gives its version. With -as-of, the policy can also read the
+metadata/ path of each KV v2 secret, which rendering with -as-of reads to find the version current
+at that time. With -name, the policy can be compared with the one of that name in Vault, and
+written to it.
+`
+
+// policyOptions - what is done with the policy generated by policy
+type policyOptions struct {
+	name       string // Of the policy in Vault
+	put        bool   // Write the policy to Vault if it has changed
+	diff       bool   // Print the changes to the policy in Vault
+	dryRun     bool   // Only report whether the policy would be written
+	outputFile string // Write the policy here rather than to stdout
+	asOf       bool   // Also grant reading the metadata of KV v2 secrets, for renders with -as-of
+}
+
+// runPolicyCommand - `talebearer policy [flags] <template>...`
+func runPolicyCommand(args []string) error {
+	fs := flag.NewFlagSet("talebearer policy", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Print(policyUsage)
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+	}
+	var opts policyOptions
+	fs.StringVar(&opts.name, "name", "", "The name of the policy in Vault, needed by -diff and -put")
+	fs.BoolVar(&opts.put, "put", false, "Write the policy to Vault, if it has changed")
+	fs.BoolVar(&opts.diff, "diff", false, "Print the changes from the policy in Vault to the "+
+		"generated one, rather than the policy itself")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "Report whether -put would write the policy, "+
+		"without writing it")
+	fs.StringVar(&opts.outputFile, "output-file", "", "The path of the file to write the policy "+
+		"to, rather than printing it")
+	format := fs.String("format", "", fmt.Sprintf("The format of the templates, one of %v. "+
+		"Detected from each file extension if not given", internal.FormatNames()))
+	fs.BoolVar(&opts.asOf, "as-of", false, "Also grant reading the metadata of KV v2 secrets, "+
+		"which rendering with -as-of needs")
+	structured := fs.Bool("structured", false, "Parse JSON and YAML templates and only find "+
+		"placeholders in string values, as talebearer -structured does")
+	conn := addVaultFlags(fs)
+	applyLogLevel := logLevelFlag(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := applyLogLevel(); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("at least one template must be given")
+	}
+	if (opts.put || opts.diff) && opts.name == "" {
+		return fmt.Errorf("-name must be specified with -put or -diff")
+	}
+
+	paths, err := templatePaths(fs.Args(), *format, *structured)
+	if err != nil {
+		return err
+	}
+	versions, err := conn.versions()
+	if err != nil {
+		return err
+	}
+	clientOpts, err := conn.options()
+	if err != nil {
+		return err
+	}
+
+	// Only -put writes to Vault, and a dry run logs the write rather than making it
+	client, err := vault.NewVaultClient(!opts.put || opts.dryRun, clientOpts...)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// A policy can be generated without Vault if the version of every mount read from is given
+	if !mountsDeclared(paths, versions) || opts.put || opts.diff {
+		if err := client.Authenticate(ctx, conn.role); err != nil {
+			return fmt.Errorf("failed authenticating with Vault: %s", err)
+		}
+		defer func() {
+			revokeCtx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+			defer cancel()
+			if err := client.RevokeTokens(revokeCtx); err != nil {
+				log.Warn(err)
+			}
+		}()
+	}
+
+	policy, err := generatePolicy(ctx, client, paths, opts.asOf)
+	if err != nil {
+		return err
+	}
+	return applyPolicy(client, policy, opts)
+}
+
+// templatePaths - the sorted paths of the secrets which the placeholders in the templates read
+// from Vault, each only once
+func templatePaths(files []string, format string, structured bool) ([]string, error) {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, file := range files {
+		template, err := newTemplate(&talebearerConfig{
+			inputFile:  file,
+			format:     format,
+			structured: structured,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed creating template %s: %s", file, err)
+		}
+		placeholders, err := template.FindPlaceholders()
+		if err != nil {
+			return nil, fmt.Errorf("failed finding placeholders in %s: %s", file, err)
+		}
+		for _, placeholder := range placeholders {
+			secret, err := internal.NewSecret(placeholder)
+			if err != nil {
+				return nil, fmt.Errorf("invalid placeholder %s in %s: %s", placeholder, file, err)
+			}
+			if secret.Scheme() != backend.DefaultScheme || seen[secret.Path()] {
+				continue
+			}
+			seen[secret.Path()] = true
+			paths = append(paths, secret.Path())
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// mountsDeclared - whether every path is on one of the mounts whose versions are given, so that
+// none has to be looked up in Vault
+func mountsDeclared(paths []string, versions map[string]int) bool {
+	for _, path := range paths {
+		p := strings.Trim(path, "/")
+		declared := false
+		for mount := range versions {
+			if p == mount || strings.HasPrefix(p, mount+"/") {
+				declared = true
+				break
+			}
+		}
+		if !declared {
+			return false
+		}
+	}
+	return true
+}
+
+// generatePolicy - a policy which can read the secrets at paths, from the paths Vault serves
+// them at, in order. With asOf, it can also read the metadata of the KV v2 secrets.
+func generatePolicy(ctx context.Context, client vault.Vault, paths []string, asOf bool) (string, error) {
+	seen := make(map[string]bool)
+	var secretPaths []string
+	var errStrings []string
+	for _, path := range paths {
+		p, err := client.SecretPath(ctx, path)
+		if err != nil {
+			errStrings = append(errStrings, fmt.Sprintf("\"%s: %s\"", path, err))
+			continue
+		}
+		if !seen[p] {
+			seen[p] = true
+			secretPaths = append(secretPaths, p)
+		}
+		if !asOf {
+			continue
+		}
+		// Only KV v2 secrets have metadata
+		version, err := client.MountVersion(ctx, path)
+		if err != nil {
+			errStrings = append(errStrings, fmt.Sprintf("\"%s: %s\"", path, err))
+			continue
+		}
+		if version != 2 {
+			continue
+		}
+		m, err := client.MetadataPath(ctx, path)
+		if err != nil {
+			errStrings = append(errStrings, fmt.Sprintf("\"%s: %s\"", path, err))
+			continue
+		}
+		if !seen[m] {
+			seen[m] = true
+			secretPaths = append(secretPaths, m)
+		}
+	}
+	if len(errStrings) > 0 {
+		return "", fmt.Errorf("failed finding the paths of secrets: [%s]", strings.Join(errStrings, ", "))
+	}
+	sort.Strings(secretPaths)
+
+	blocks := make([]string, 0, len(secretPaths))
+	for _, p := range secretPaths {
+		blocks = append(blocks, fmt.Sprintf("path %q {\n  capabilities = [\"read\"]\n}\n", p))
+	}
+	return strings.Join(blocks, "\n"), nil
+}
+
+// applyPolicy - print or write the policy, print its changes from the one in Vault, or write it
+// to Vault, as opts say
+func applyPolicy(client vault.Vault, policy string, opts policyOptions) error {
+	if !opts.put && !opts.diff {
+		return writePolicy(policy, opts.outputFile)
+	}
+
+	current, err := client.GetPolicy(opts.name)
+	if err != nil {
+		return fmt.Errorf("failed reading policy %s: %s", opts.name, err)
+	}
+	unchanged := strings.TrimSpace(current) == strings.TrimSpace(policy)
+	if opts.diff && !unchanged {
+		if err := writePolicy(diffPolicy(opts.name, current, policy), opts.outputFile); err != nil {
+			return err
+		}
+	}
+	if unchanged {
+		log.Infof("Policy %s is unchanged", opts.name)
+		return nil
+	}
+	if !opts.put {
+		return nil
+	}
+
+	action := "Updated"
+	if current == "" {
+		action = "Created"
+	}
+	if opts.dryRun {
+		log.Infof("Would write policy %s", opts.name)
+	}
+	if err := client.PutPolicy(opts.name, policy); err != nil {
+		return fmt.Errorf("failed writing policy %s: %s", opts.name, err)
+	}
+	if !opts.dryRun {
+		log.Infof("%s policy %s", action, opts.name)
+	}
+	return nil
+}
+
+// writePolicy - write s to file, or to stdout if file is empty
+func writePolicy(s string, file string) error {
+	if file == "" {
+		fmt.Print(s)
+		return nil
+	}
+	return ioutil.WriteFile(file, []byte(s), 0644)
+}
+
+// diffPolicy - the lines removed from current and added by generated, as a unified diff
+// without hunks
+func diffPolicy(name string, current, generated string) string {
+	a := splitLines(current)
+	b := splitLines(generated)
+
+	// lcs[i][j] - the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s (Vault)\n+++ %s (generated)\n", name, name)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&out, " %s\n", a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&out, "-%s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "+%s\n", b[j])
+			j++
+		}
+	}
+	return out.String()
+}
+
+// splitLines - the lines of s, without a trailing empty line
+func splitLines(s string) []string {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/al4/talebearer/vault/vaulttest"
+)
+
+const kvVersionsPolicy = `path "legacy/example" {
+  capabilities = ["read"]
+}
+
+path "secret/data/example" {
+  capabilities = ["read"]
+}
+`
+
+func TestTemplatePaths(t *testing.T) {
+	paths, err := templatePaths([]string{
+		"examples/kv-versions.properties", "examples/example.properties",
+	}, "", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"legacy/example", "secret/example"}, paths)
+
+	_, err = templatePaths([]string{"examples/nonexisting.properties"}, "", false)
+	assert.Error(t, err)
+}
+
+func TestGeneratePolicy(t *testing.T) {
+	_, client := newFakeVaultClient(t, true)
+
+	policy, err := generatePolicy(context.Background(), client,
+		[]string{"legacy/example", "secret/example"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, kvVersionsPolicy, policy)
+
+	policy, err = generatePolicy(context.Background(), client,
+		[]string{"legacy/example", "secret/example"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, kvVersionsPolicy+`
+path "secret/metadata/example" {
+  capabilities = ["read"]
+}
+`, policy)
+
+	_, err = generatePolicy(context.Background(), client, []string{"missing/example"}, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed finding the paths of secrets: [\"missing/example: ")
+}
+
+func TestRunPolicyCommand_SomeMountsDeclared(t *testing.T) {
+	srv := vaulttest.NewTestServer(t, "examples/vault.yaml")
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("HOME", t.TempDir())
+	output := filepath.Join(t.TempDir(), "policy.hcl")
+
+	// legacy's version isn't given, so is looked up, which needs a login
+	err := runPolicyCommand([]string{
+		"-mount-versions", "secret=2", "-output-file", output,
+		"-auth-method", "approle",
+		"-auth-param", "role_id=app-role-id", "-auth-param", "secret_id=app-secret-id",
+		"examples/kv-versions.properties",
+	})
+	require.NoError(t, err)
+	policy, err := ioutil.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, kvVersionsPolicy, string(policy))
+	assert.Contains(t, srv.Requests(), "PUT auth/approle/login")
+	assert.Contains(t, srv.Requests(), "GET sys/internal/ui/mounts/legacy/example")
+}
+
+func TestMountsDeclared(t *testing.T) {
+	versions := map[string]int{"secret": 2, "team/kv": 2}
+	assert.True(t, mountsDeclared([]string{"secret/app", "/team/kv/app/db"}, versions))
+	assert.False(t, mountsDeclared([]string{"secret/app", "legacy/app"}, versions))
+	assert.False(t, mountsDeclared([]string{"secretive/app"}, versions))
+	assert.False(t, mountsDeclared([]string{"team/app"}, versions))
+}
+
+func TestApplyPolicy_PutAndDiff(t *testing.T) {
+	diffFile := filepath.Join(t.TempDir(), "policy.diff")
+
+	// A dry run uses a read-only client, so writes nothing
+	_, client := newFakeVaultClient(t, true)
+	err := applyPolicy(client, kvVersionsPolicy, policyOptions{name: "app", put: true, dryRun: true})
+	require.NoError(t, err)
+	current, err := client.GetPolicy("app")
+	require.NoError(t, err)
+	assert.Equal(t, "", current)
+
+	_, client = newFakeVaultClient(t, false)
+	err = applyPolicy(client, kvVersionsPolicy, policyOptions{name: "app", put: true})
+	require.NoError(t, err)
+	current, err = client.GetPolicy("app")
+	require.NoError(t, err)
+	assert.Equal(t, kvVersionsPolicy, current)
+
+	// An unchanged policy has no diff
+	err = applyPolicy(client, kvVersionsPolicy, policyOptions{name: "app", diff: true, outputFile: diffFile})
+	require.NoError(t, err)
+	assert.NoFileExists(t, diffFile)
+
+	generated := `path "secret/data/example" {
+  capabilities = ["read"]
+}
+`
+	err = applyPolicy(client, generated, policyOptions{name: "app", diff: true, outputFile: diffFile})
+	require.NoError(t, err)
+	diff, err := ioutil.ReadFile(diffFile)
+	require.NoError(t, err)
+	assert.Equal(t, `--- app (Vault)
++++ app (generated)
+-path "legacy/example" {
+-  capabilities = ["read"]
+-}
+-
+ path "secret/data/example" {
+   capabilities = ["read"]
+ }
+`, string(diff))
+}
+
+func TestDiffPolicy(t *testing.T) {
+	assert.Equal(t, "--- new (Vault)\n+++ new (generated)\n+a\n+b\n", diffPolicy("new", "", "a\nb\n"))
+	assert.Equal(t, "--- p (Vault)\n+++ p (generated)\n a\n-b\n+c\n d\n",
+		diffPolicy("p", "a\nb\nd", "a\nc\nd\n"))
+}
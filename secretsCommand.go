@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/al4/talebearer/backend"
+)
+
+const secretsUsage = `Usage: talebearer secrets <create|edit|rekey> -file <secrets file> [flags]
+
+Manage an encrypted secrets file, which holds secrets at the same paths as Vault and can be
+rendered with -secrets-file instead of reading from Vault. The key is read from -key-file, or
+from the %s environment variable if no key file is given.
+
+  create  Create a new secrets file, generating a key if -key-file doesn't exist
+  edit    Decrypt the secrets file into an editor ($VISUAL or $EDITOR), and encrypt it again
+  rekey   Encrypt the secrets file with a newly generated key
+`
+
+// runSecretsCommand - `talebearer secrets <action> [flags]`
+func runSecretsCommand(args []string) error {
+	usage := fmt.Sprintf(secretsUsage, backend.KeyEnvVar)
+	if len(args) == 0 {
+		fmt.Print(usage)
+		return fmt.Errorf("no action given")
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("talebearer secrets "+action, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Print(usage)
+		fmt.Printf("\nFlags of %s:\n", action)
+		fs.PrintDefaults()
+	}
+	file := fs.String("file", "", "The path of the encrypted secrets file")
+	keyFile := fs.String("key-file", "", fmt.Sprintf("The path of the key file, otherwise the "+
+		"key is read from %s", backend.KeyEnvVar))
+	applyLogLevel := logLevelFlag(fs)
+
+	var from, newKeyFile *string
+	switch action {
+	case "create":
+		from = fs.String("from", "", "A plaintext JSON file of secrets to start with, an object "+
+			"of paths to objects of keys and values")
+	case "edit":
+	case "rekey":
+		newKeyFile = fs.String("new-key-file", "", "The path to write the new key to, by "+
+			"default the key file")
+	default:
+		fmt.Print(usage)
+		return fmt.Errorf("unknown action %q", action)
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if err := applyLogLevel(); err != nil {
+		return err
+	}
+	if *file == "" {
+		fs.Usage()
+		return fmt.Errorf("-file must be specified")
+	}
+
+	switch action {
+	case "create":
+		return createSecretsFile(*file, *keyFile, *from)
+	case "edit":
+		return editSecretsFile(*file, *keyFile, editor())
+	default:
+		return rekeySecretsFile(*file, *keyFile, *newKeyFile)
+	}
+}
+
+// createSecretsFile - create a secrets file with the secrets in the plaintext file from, if
+// given. A key is generated if keyFile is given and doesn't exist.
+func createSecretsFile(file string, keyFile string, from string) error {
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("%s already exists, use `talebearer secrets edit` to change it", file)
+	}
+
+	secrets := backend.SecretsFile{}
+	if from != "" {
+		plaintext, err := ioutil.ReadFile(from)
+		if err != nil {
+			return err
+		}
+		secrets, err = backend.ParseSecrets(plaintext)
+		if err != nil {
+			return fmt.Errorf("failed reading %s: %s", from, err)
+		}
+	}
+
+	var key []byte
+	var err error
+	if _, statErr := os.Stat(keyFile); keyFile != "" && os.IsNotExist(statErr) {
+		key, err = backend.GenerateKey()
+		if err != nil {
+			return err
+		}
+		err = backend.WriteFileAtomic(keyFile, []byte(backend.EncodeKey(key)+"\n"), 0600)
+		if err != nil {
+			return fmt.Errorf("failed writing key: %s", err)
+		}
+		log.Infof("Generated a new key in %s, keep it out of version control", keyFile)
+	} else {
+		key, err = backend.LoadKey(keyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := backend.WriteSecretsFile(file, key, secrets); err != nil {
+		return fmt.Errorf("failed writing to file '%s': %s", file, err)
+	}
+	log.Infof("Created %s with %d secrets", file, len(secrets))
+	return nil
+}
+
+// editSecretsFile - decrypt a secrets file to a temporary file, run the editor on it, and
+// encrypt the result again. The plaintext is removed once the editor exits.
+func editSecretsFile(file string, keyFile string, editor string) error {
+	key, err := backend.LoadKey(keyFile)
+	if err != nil {
+		return err
+	}
+	secrets, err := backend.ReadSecretsFile(file, key)
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	plaintext = append(plaintext, '\n')
+
+	dir, err := ioutil.TempDir("", "talebearer")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, filepath.Base(file)+".json")
+	if err := ioutil.WriteFile(tmp, plaintext, 0600); err != nil {
+		return err
+	}
+
+	// Run through the shell, so the editor can include arguments, as git does
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, tmp)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %s", editor, err)
+	}
+
+	edited, err := ioutil.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, plaintext) {
+		log.Infof("No changes to %s", file)
+		return nil
+	}
+	secrets, err = backend.ParseSecrets(edited)
+	if err != nil {
+		return fmt.Errorf("not saving invalid secrets: %s", err)
+	}
+
+	if err := backend.WriteSecretsFile(file, key, secrets); err != nil {
+		return fmt.Errorf("failed writing to file '%s': %s", file, err)
+	}
+	log.Infof("Saved %s with %d secrets", file, len(secrets))
+	return nil
+}
+
+// rekeySecretsFile - encrypt a secrets file with a new key, which is written to newKeyFile, or
+// keyFile if that is empty
+func rekeySecretsFile(file string, keyFile string, newKeyFile string) error {
+	if newKeyFile == "" {
+		newKeyFile = keyFile
+	}
+	if newKeyFile == "" {
+		return fmt.Errorf("-new-key-file must be specified when the key is read from %s",
+			backend.KeyEnvVar)
+	}
+
+	key, err := backend.LoadKey(keyFile)
+	if err != nil {
+		return err
+	}
+	secrets, err := backend.ReadSecretsFile(file, key)
+	if err != nil {
+		return err
+	}
+	newKey, err := backend.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	// Write the re-encrypted file under a temporary name, and only replace the file once the new
+	// key has been written, keeping the old key until then, so neither is lost on failure
+	tmp := file + ".rekey"
+	if err := backend.WriteSecretsFile(tmp, newKey, secrets); err != nil {
+		return fmt.Errorf("failed writing to file '%s': %s", tmp, err)
+	}
+	defer os.Remove(tmp)
+
+	backup := ""
+	if contents, err := ioutil.ReadFile(newKeyFile); err == nil {
+		backup = newKeyFile + ".old"
+		if err := backend.WriteFileAtomic(backup, contents, 0600); err != nil {
+			return fmt.Errorf("failed backing up key: %s", err)
+		}
+	}
+	if err := backend.WriteFileAtomic(newKeyFile, []byte(backend.EncodeKey(newKey)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed writing key: %s", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		if backup != "" {
+			return fmt.Errorf("failed writing to file '%s', the previous key is in %s: %s",
+				file, backup, err)
+		}
+		return fmt.Errorf("failed writing to file '%s': %s", file, err)
+	}
+	if backup != "" {
+		_ = os.Remove(backup)
+	}
+
+	log.Infof("Re-encrypted %s with a new key in %s", file, newKeyFile)
+	return nil
+}
+
+// editor - the user's editor, as git chooses it
+func editor() string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if e := os.Getenv(env); e != "" {
+			return e
+		}
+	}
+	return "vi"
+}
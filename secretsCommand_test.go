@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/al4/talebearer/backend"
+	"github.com/al4/talebearer/vault"
+)
+
+func TestSecretsCommand_CreateAndRender(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "dev.secrets")
+	keyFile := filepath.Join(dir, "dev.key")
+	from := filepath.Join(dir, "plain.json")
+	assert.NoError(t, ioutil.WriteFile(from, []byte(`{"secret/example": {"foo": "bar", "two": "2"}}`), 0600))
+
+	err := runSubcommand([]string{"secrets", "create", "-file", file, "-key-file", keyFile, "-from", from})
+	assert.NoError(t, err)
+
+	err = runSubcommand([]string{"secrets", "create", "-file", file, "-key-file", keyFile})
+	assert.Contains(t, err.Error(), "already exists")
+
+	// Vault isn't used, so Authenticate isn't expected
+	mockClient := new(vault.MockClient)
+	config := &talebearerConfig{
+		inputFile:      "examples/example.properties",
+		outputFile:     filepath.Join(dir, "out.properties"),
+		secretsFile:    file,
+		secretsKeyFile: keyFile,
+	}
+	assert.NoError(t, Run(context.Background(), mockClient, config))
+	rendered, _ := ioutil.ReadFile(config.outputFile)
+	assert.Equal(t, "public=blah\nsecret=bar\nsecret-two=2\nbaz=boz\n", string(rendered))
+	mockClient.AssertExpectations(t)
+}
+
+func TestSecretsCommand_Edit(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "dev.secrets")
+	keyFile := filepath.Join(dir, "dev.key")
+	assert.NoError(t, createSecretsFile(file, keyFile, ""))
+
+	editor := filepath.Join(dir, "editor.sh")
+	script := "#!/bin/sh\nprintf '{\"secret/new\": {\"key\": \"value\"}}' > \"$1\"\n"
+	assert.NoError(t, ioutil.WriteFile(editor, []byte(script), 0700))
+	assert.NoError(t, editSecretsFile(file, keyFile, editor))
+
+	key, _ := backend.LoadKey(keyFile)
+	secrets, err := backend.ReadSecretsFile(file, key)
+	assert.NoError(t, err)
+	assert.Equal(t, backend.SecretsFile{"secret/new": {"key": "value"}}, secrets)
+
+	// Invalid edits aren't saved
+	assert.NoError(t, ioutil.WriteFile(editor, []byte("#!/bin/sh\necho '[]' > \"$1\"\n"), 0700))
+	err = editSecretsFile(file, keyFile, editor)
+	assert.Contains(t, err.Error(), "not saving invalid secrets")
+	secrets, _ = backend.ReadSecretsFile(file, key)
+	assert.Equal(t, backend.SecretsFile{"secret/new": {"key": "value"}}, secrets)
+}
+
+func TestSecretsCommand_Rekey(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "dev.secrets")
+	keyFile := filepath.Join(dir, "dev.key")
+	assert.NoError(t, createSecretsFile(file, keyFile, ""))
+	oldKey, _ := backend.LoadKey(keyFile)
+
+	assert.NoError(t, rekeySecretsFile(file, keyFile, ""))
+
+	newKey, err := backend.LoadKey(keyFile)
+	assert.NoError(t, err)
+	assert.NotEqual(t, oldKey, newKey)
+	_, err = backend.ReadSecretsFile(file, newKey)
+	assert.NoError(t, err)
+	_, err = backend.ReadSecretsFile(file, oldKey)
+	assert.Error(t, err)
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 2, "no temporary or backup files should be left")
+}
+
+func TestSecretsCommand_Usage(t *testing.T) {
+	err := runSubcommand([]string{"secrets", "destroy"})
+	assert.EqualError(t, err, `unknown action "destroy"`)
+
+	err = runSubcommand([]string{"secrets", "edit"})
+	assert.EqualError(t, err, "-file must be specified")
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/al4/talebearer/backend"
+	"github.com/al4/talebearer/vault"
+)
+
+const seedUsage = `Usage: talebearer seed -file <file> [flags]
+
+Write secrets into Vault from a file of paths and their keys and values, in YAML or JSON, e.g.
+
+  secret/app: {user: app, password: hunter2}
+
+or as properties, one key per line, e.g.
+
+  secret/app!password=hunter2
+
+Each secret is replaced by the keys in the file, unless -merge is given. Only the names of keys
+which change are reported, never their values.
+`
+
+// seedFormats - parsers of the file formats seed reads, by name
+var seedFormats = map[string]func([]byte) (backend.SecretsFile, error){
+	"json":       backend.ParseSecrets,
+	"properties": parsePropertiesSecrets,
+	"yaml":       parseYAMLSecrets,
+}
+
+// seedOptions - how secrets are written by seed
+type seedOptions struct {
+	merge  bool // Keep keys which aren't in the file, rather than replacing each secret
+	cas    bool // Write KV v2 secrets only if they haven't changed since they were read
+	dryRun bool // Only report what would change
+}
+
+// runSeedCommand - `talebearer seed [flags]`
+func runSeedCommand(args []string) error {
+	fs := flag.NewFlagSet("talebearer seed", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Print(seedUsage)
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+	}
+	file := fs.String("file", "", "The path of the file of secrets to write")
+	format := fs.String("format", "", "The format of the file, one of json, properties or yaml. "+
+		"Detected from the file extension if not given")
+	var opts seedOptions
+	fs.BoolVar(&opts.merge, "merge", false, "Merge the keys in the file into each secret, "+
+		"keeping its other keys, rather than replacing it")
+	fs.BoolVar(&opts.cas, "cas", false, "Write KV v2 secrets with check-and-set, so that a "+
+		"secret which someone else changes while seeding isn't overwritten")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "Report what would change, without writing")
+	conn := addVaultFlags(fs)
+	applyLogLevel := logLevelFlag(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := applyLogLevel(); err != nil {
+		return err
+	}
+	if *file == "" {
+		fs.Usage()
+		return fmt.Errorf("-file must be specified")
+	}
+
+	secrets, err := readSeedFile(*file, *format)
+	if err != nil {
+		return err
+	}
+	clientOpts, err := conn.options()
+	if err != nil {
+		return err
+	}
+
+	// A dry run uses a read-only client, which logs writes rather than making them
+	client, err := vault.NewVaultClient(opts.dryRun, clientOpts...)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := client.Authenticate(ctx, conn.role); err != nil {
+		return fmt.Errorf("failed authenticating with Vault: %s", err)
+	}
+	defer func() {
+		revokeCtx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+		defer cancel()
+		if err := client.RevokeTokens(revokeCtx); err != nil {
+			log.Warn(err)
+		}
+	}()
+
+	return seed(ctx, client, secrets, opts)
+}
+
+// readSeedFile - read the secrets in file, in the given format or the one its extension implies
+func readSeedFile(file string, format string) (backend.SecretsFile, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), ".")
+		if format == "yml" {
+			format = "yaml"
+		}
+	}
+	parse, ok := seedFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q, valid formats are [json properties yaml]", format)
+	}
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := parse(contents)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading %s: %s", file, err)
+	}
+	return secrets, nil
+}
+
+// parseYAMLSecrets - parse a YAML mapping of paths to mappings of keys and values
+func parseYAMLSecrets(contents []byte) (backend.SecretsFile, error) {
+	var secrets backend.SecretsFile
+	if err := yaml.Unmarshal(contents, &secrets); err != nil {
+		return nil, fmt.Errorf("secrets must be a YAML mapping of paths to mappings: %s", err)
+	}
+	return secrets, nil
+}
+
+// parsePropertiesSecrets - parse properties of the form path!key=value
+func parsePropertiesSecrets(contents []byte) (backend.SecretsFile, error) {
+	secrets := backend.SecretsFile{}
+	for property, value := range backend.ParseProperties(string(contents)) {
+		parts := strings.SplitN(property, "!", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("property %q must be of the form path!key", property)
+		}
+		if secrets[parts[0]] == nil {
+			secrets[parts[0]] = make(map[string]interface{})
+		}
+		secrets[parts[0]][parts[1]] = value
+	}
+	return secrets, nil
+}
+
+// seed - write each secret to Vault, in order of path. A failure to write one secret doesn't stop
+// the others being written.
+func seed(ctx context.Context, client vault.Vault, secrets backend.SecretsFile, opts seedOptions) error {
+	paths := make([]string, 0, len(secrets))
+	for path := range secrets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var errStrings []string
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("stopped seeding secrets: %s", err)
+		}
+		if err := seedSecret(ctx, client, path, secrets[path], opts); err != nil {
+			errStrings = append(errStrings, fmt.Sprintf("\"%s: %s\"", path, err))
+		}
+	}
+	if len(errStrings) > 0 {
+		return fmt.Errorf("failed seeding secrets: [%s]", strings.Join(errStrings, ", "))
+	}
+	return nil
+}
+
+// seedSecret - write fields to the secret at path, if that changes it
+func seedSecret(ctx context.Context, client vault.Vault, path string, fields map[string]interface{},
+	opts seedOptions) error {
+	mountVersion, err := client.MountVersion(ctx, path)
+	if err != nil {
+		return err
+	}
+	current, version, err := readCurrent(ctx, client, path, mountVersion)
+	if err != nil {
+		return err
+	}
+
+	desired := make(map[string]interface{}, len(fields))
+	if opts.merge {
+		for k, v := range current {
+			desired[k] = v
+		}
+	}
+	for k, v := range fields {
+		desired[k] = v
+	}
+
+	added, changed, removed := diffFields(current, desired)
+	if len(added)+len(changed)+len(removed) == 0 {
+		log.Infof("%s is unchanged", path)
+		return nil
+	}
+	action, done := "update", "Updated"
+	if current == nil {
+		action, done = "create", "Created"
+	}
+	change := fmt.Sprintf("%s: added %v, changed %v, removed %v", path, added, changed, removed)
+	if opts.dryRun {
+		log.Infof("Would %s %s", action, change)
+	}
+
+	data := desired
+	if mountVersion == 2 {
+		data = map[string]interface{}{"data": desired}
+		if opts.cas {
+			data["options"] = map[string]interface{}{"cas": version}
+		}
+	}
+	if _, err := client.Write(ctx, path, data); err != nil {
+		return err
+	}
+	if !opts.dryRun {
+		log.Infof("%s %s", done, change)
+	}
+	return nil
+}
+
+// readCurrent - the fields of the secret at path, or nil if there is none, and for KV v2 its
+// current version, which is 0 if it has never been written
+func readCurrent(ctx context.Context, client vault.Vault, path string, mountVersion int) (map[string]interface{}, int, error) {
+	secret, err := client.Read(ctx, path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, 0, nil
+	}
+	if mountVersion != 2 {
+		return secret.Data, 0, nil
+	}
+
+	// A deleted KV v2 secret still has a version, but no data
+	var version int
+	if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+		if n, ok := metadata["version"].(json.Number); ok {
+			v, err := n.Int64()
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid version of %s: %s", path, n)
+			}
+			version = int(v)
+		}
+	}
+	fields, _ := secret.Data["data"].(map[string]interface{})
+	return fields, version, nil
+}
+
+// diffFields - the sorted names of the keys which are added, changed and removed going from
+// current to desired
+func diffFields(current, desired map[string]interface{}) (added, changed, removed []string) {
+	for k, v := range desired {
+		old, ok := current[k]
+		switch {
+		case !ok:
+			added = append(added, k)
+		case !sameValue(old, v):
+			changed = append(changed, k)
+		}
+	}
+	for k := range current {
+		if _, ok := desired[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return added, changed, removed
+}
+
+// sameValue - whether two values are equal once written as JSON, so that e.g. the number 5 in
+// a YAML file is the same as the json.Number 5 read from Vault
+func sameValue(a, b interface{}) bool {
+	aJSON, aErr := json.Marshal(a)
+	bJSON, bErr := json.Marshal(b)
+	if aErr != nil || bErr != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return string(aJSON) == string(bJSON)
+}
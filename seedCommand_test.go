@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	vaultApi "github.com/hashicorp/vault/api"
+
+	"github.com/al4/talebearer/backend"
+	"github.com/al4/talebearer/vault"
+)
+
+func TestSeed_ReplaceAndMerge(t *testing.T) {
+	_, client := newFakeVaultClient(t, false)
+	ctx := context.Background()
+
+	err := seed(ctx, client, backend.SecretsFile{
+		"secret/example": {"other": "x"},
+		"legacy/example": {"other": "y"},
+		"secret/new":     {"port": 5432},
+	}, seedOptions{})
+	require.NoError(t, err)
+
+	err = seed(ctx, client, backend.SecretsFile{
+		"secret/example": {"key": "value2"},
+		"legacy/example": {"key": "value2"},
+	}, seedOptions{merge: true})
+	require.NoError(t, err)
+
+	for path, expected := range map[string]map[string]interface{}{
+		"secret/example": {"key": "value2", "other": "x"},
+		"legacy/example": {"key": "value2", "other": "y"},
+		"secret/new":     {"port": "5432"},
+	} {
+		fields, err := client.ReadDocument(ctx, path, 0)
+		require.NoError(t, err)
+		// Numbers are read back as json.Number
+		for k, v := range fields {
+			fields[k] = fmt.Sprint(v)
+		}
+		assert.Equal(t, expected, fields, path)
+	}
+}
+
+func TestSeed_DryRun(t *testing.T) {
+	srv, client := newFakeVaultClient(t, true)
+	ctx := context.Background()
+
+	err := seed(ctx, client, backend.SecretsFile{"secret/example": {"key": "changed"}}, seedOptions{dryRun: true})
+	require.NoError(t, err)
+
+	fields, err := client.ReadDocument(ctx, "secret/example", 0)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"key": "value1"}, fields)
+	for _, r := range srv.Requests() {
+		assert.False(t, strings.HasPrefix(r, "PUT") || strings.HasPrefix(r, "POST"), r)
+	}
+}
+
+// racingClient - a client which writes the secret after it is read, as someone else seeding at
+// the same time would
+type racingClient struct {
+	vault.Vault
+}
+
+func (c *racingClient) Read(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	secret, err := c.Vault.Read(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.Vault.Write(ctx, path, map[string]interface{}{
+		"data": map[string]interface{}{"key": "theirs"},
+	})
+	return secret, err
+}
+
+func TestSeed_CheckAndSet(t *testing.T) {
+	_, client := newFakeVaultClient(t, false)
+	ctx := context.Background()
+	secrets := backend.SecretsFile{"secret/example": {"key": "ours"}}
+
+	err := seed(ctx, &racingClient{client}, secrets, seedOptions{cas: true})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "check-and-set parameter did not match the current version")
+	fields, err := client.ReadDocument(ctx, "secret/example", 0)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"key": "theirs"}, fields)
+
+	// Without a race, the check passes
+	assert.NoError(t, seed(ctx, client, secrets, seedOptions{cas: true}))
+	fields, err = client.ReadDocument(ctx, "secret/example", 0)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"key": "ours"}, fields)
+}
+
+func TestReadSeedFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"seed.yml":        "secret/app:\n  user: app\n  password: hunter2\n",
+		"seed.json":       `{"secret/app": {"user": "app", "password": "hunter2"}}`,
+		"seed.properties": "secret/app!user=app\nsecret/app!password=hunter2\n",
+	}
+	for name, contents := range files {
+		file := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte(contents), 0600))
+		secrets, err := readSeedFile(file, "")
+		require.NoError(t, err, name)
+		assert.Equal(t, backend.SecretsFile{
+			"secret/app": {"user": "app", "password": "hunter2"},
+		}, secrets, name)
+	}
+
+	invalid := filepath.Join(dir, "invalid.properties")
+	require.NoError(t, ioutil.WriteFile(invalid, []byte("password=hunter2\n"), 0600))
+	_, err := readSeedFile(invalid, "")
+	assert.EqualError(t, err, `failed reading `+invalid+`: property "password" must be of the form path!key`)
+
+	_, err = readSeedFile(invalid, "toml")
+	assert.EqualError(t, err, `unknown format "toml", valid formats are [json properties yaml]`)
+}
@@ -1,29 +1,107 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/al4/talebearer/backend"
 	"github.com/al4/talebearer/internal"
 	"github.com/al4/talebearer/vault"
 )
 
 var flags = flag.NewFlagSet("Talebearer", flag.ExitOnError)
+
+// revokeTimeout - how long to wait for Vault when revoking tokens on exit
+const revokeTimeout = 10 * time.Second
+
 var logLevel string
 var outputFile string
+var vaultConn *vaultFlags // How to connect and log in to Vault
 var inputFile string
-var vaultRole string
+var childTokenTTL time.Duration
+var childTokenUses int
+var renewToken bool
+var format string
 var inPlace bool
+var structured bool
+var asOf string
+var wildcardPrefix string
+var wildcardSeparator string
+var wildcardKeyCase string
+var concurrency int
+var secretsFile string
+var secretsKeyFile string
+var maxRetries int
+var retryWaitMin time.Duration
+var retryWaitMax time.Duration
+var requestTimeout time.Duration
+var timeout time.Duration
 var continueOnError bool
 
+// keyValueFlag - a flag which may be given many times, each as key=value
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f keyValueFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("%q must be of the form key=value", s)
+	}
+	f[parts[0]] = parts[1]
+	return nil
+}
+
 type talebearerConfig struct {
 	inputFile  string
 	outputFile string
 	vaultRole  string
+
+	authMethod string
+	authParams map[string]string // Including the mount, if given
+	format     string
+	structured bool
+	asOf       time.Time
+
+	agentSinkFile string // Read the token from a Vault Agent sink, rather than logging in
+	tokenHelper   string
+
+	tls vault.TLSConfig // Overrides the VAULT_CACERT etc. environment variables
+
+	childTokenTTL  time.Duration // Render with a child token, if either is set
+	childTokenUses int
+	renewToken     bool
+
+	mountVersions map[string]int // KV API versions of mounts, which are then not looked up
+
+	retry          vault.RetryPolicy
+	requestTimeout time.Duration // Per request to Vault, including retries
+	timeout        time.Duration // For the whole render
+
+	wildcardPrefix    string
+	wildcardSeparator string
+	wildcardKeyCase   string
+
+	concurrency int // Documents read at once
+
+	secretsFile    string // Read secrets from this encrypted file rather than from Vault
+	secretsKeyFile string
 }
 
 func init() {
@@ -37,8 +115,77 @@ func init() {
 	flags.StringVar(
 		&outputFile, "output-file", "", "The path of the properties file to write",
 	)
+	vaultConn = addVaultFlags(flags)
+	flags.DurationVar(
+		&childTokenTTL, "child-token-ttl", 0, "Render with a child token which expires after "+
+			"this long, e.g. 5m, so that a leaked token is soon useless",
+	)
+	flags.IntVar(
+		&childTokenUses, "child-token-uses", 0, "Render with a child token which can only be "+
+			"used this many times. Each secret read, KV mount lookup and its revocation is a use",
+	)
+	flags.BoolVar(
+		&renewToken, "renew-token", false, "Keep renewing the token before it expires, for "+
+			"renders which may take longer than its TTL. Can't be used with -child-token-ttl",
+	)
 	flags.StringVar(
-		&vaultRole, "role", "", "The Vault role to authenticate as",
+		&format, "format", "", fmt.Sprintf("The format of the input file, which determines how "+
+			"secret values are escaped, one of %v. Detected from the file extension if not given, "+
+			"otherwise values are not escaped", internal.FormatNames()),
+	)
+	flags.BoolVar(
+		&structured, "structured", false, "Parse JSON and YAML input files and only substitute "+
+			"placeholders in string values, allowing non-string secrets to keep their type",
+	)
+	flags.StringVar(
+		&asOf, "as-of", "", "Read KV v2 secrets as they were at this time, in RFC 3339 format "+
+			"(e.g. 2019-03-01T12:00:00Z). Reading a secret from a KV v1 mount is an error",
+	)
+	flags.IntVar(
+		&maxRetries, "max-retries", vault.DefaultRetryPolicy.MaxRetries, "Retries of a request "+
+			"to Vault after a connection error or 5xx response. -1 to use VAULT_MAX_RETRIES, "+
+			"or 2 if that isn't set",
+	)
+	flags.DurationVar(
+		&retryWaitMin, "retry-wait-min", vault.DefaultRetryPolicy.MinWait, "Wait before the "+
+			"first retry of a request to Vault, doubling for each retry after it",
+	)
+	flags.DurationVar(
+		&retryWaitMax, "retry-wait-max", vault.DefaultRetryPolicy.MaxWait, "Longest wait "+
+			"before a retry of a request to Vault. Each wait is jittered by up to half",
+	)
+	flags.DurationVar(
+		&requestTimeout, "request-timeout", 0, "Give up on a request to Vault after this "+
+			"long, including its retries. 0 to use VAULT_CLIENT_TIMEOUT, or 60s if that isn't set",
+	)
+	flags.DurationVar(
+		&timeout, "timeout", 0, "Give up on rendering the template after this long, e.g. 2m. "+
+			"0 for no limit",
+	)
+	flags.StringVar(
+		&wildcardPrefix, "wildcard-prefix", "", "Prefix for each key written by a wildcard "+
+			"placeholder, e.g. {{ secret/app!* }}",
+	)
+	flags.StringVar(
+		&wildcardSeparator, "wildcard-separator", "=", "Separator between each key and value "+
+			"written by a wildcard placeholder",
+	)
+	flags.StringVar(
+		&wildcardKeyCase, "wildcard-key-case", "", "Change the case of keys written by a wildcard "+
+			"placeholder, upper or lower",
+	)
+	flags.IntVar(
+		&concurrency, "concurrency", internal.DefaultConcurrency, "The number of secret "+
+			"documents to read at once. Each document is only read once, however many "+
+			"placeholders refer to it",
+	)
+	flags.StringVar(
+		&secretsFile, "secrets-file", "", "Read secrets from this encrypted secrets file instead "+
+			"of Vault, see `talebearer secrets`",
+	)
+	flags.StringVar(
+		&secretsKeyFile, "secrets-key-file", "", fmt.Sprintf("The key file for -secrets-file, "+
+			"otherwise the key is read from %s", backend.KeyEnvVar),
 	)
 	flags.BoolVar(
 		&inPlace, "inplace", false, "Alter input-file in-place instead of writing to output-file",
@@ -50,9 +197,11 @@ func init() {
 	flags.Usage = func() {
 		fmt.Printf("Usage of Talebearer:\n")
 		flags.PrintDefaults()
+		printSubcommands()
 		fmt.Println("\nVault authentication is handled by environment variables (the same " +
 			"ones as the Vault Client, as talebearer uses the same code). So ensure VAULT_ADDR " +
-			"and VAULT_TOKEN are set.")
+			"is set, and VAULT_TOKEN or -auth-method, unless the template only reads from other " +
+			"backends, e.g. {{ env:DB_PASSWORD }} or {{ file:/run/secrets/db.json!password }}.")
 		fmt.Println()
 	}
 
@@ -65,7 +214,8 @@ func init() {
 		}
 	}
 
-	err := flags.Parse(args)
+	var err error
+	subcommandArgs, err = parseArgs(flags, args)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -73,23 +223,72 @@ func init() {
 
 func main() {
 	log.SetOutput(os.Stderr)
-	ll, err := log.ParseLevel(logLevel)
+	err := setLogLevel(logLevel)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	log.SetLevel(ll)
+
+	if subcommandArgs != nil {
+		err = runSubcommand(subcommandArgs)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		return
+	}
 
 	config, err := newTalebearerConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	vaultClient, err := vault.NewVaultClient(true)
+	var opts []vault.Option
+	if !config.asOf.IsZero() {
+		opts = append(opts, vault.WithAsOf(config.asOf))
+	}
+	if len(config.mountVersions) > 0 {
+		opts = append(opts, vault.WithMountVersions(config.mountVersions))
+	}
+	opts = append(opts, vault.WithRetryPolicy(config.retry))
+	opts = append(opts, vault.WithAuthMethod(config.authMethod, config.authParams))
+	if config.agentSinkFile != "" {
+		opts = append(opts, vault.WithAgentSink(config.agentSinkFile))
+	}
+	if config.tokenHelper != "" {
+		opts = append(opts, vault.WithTokenHelper(config.tokenHelper))
+	}
+	opts = append(opts, vault.WithTLS(config.tls))
+	if config.childTokenTTL > 0 || config.childTokenUses > 0 {
+		opts = append(opts, vault.WithChildToken(config.childTokenTTL, config.childTokenUses))
+	}
+	if config.renewToken {
+		opts = append(opts, vault.WithTokenRenewal())
+	}
+	if config.requestTimeout > 0 {
+		opts = append(opts, vault.WithRequestTimeout(config.requestTimeout))
+	}
+	vaultClient, err := vault.NewVaultClient(true, opts...)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	err = Run(vaultClient, config)
+	// Stop cleanly on interrupt, or once the timeout is reached
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if config.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.timeout)
+		defer cancel()
+	}
+
+	err = Run(ctx, vaultClient, config)
+
+	// Revoke the tokens talebearer created even if ctx was cancelled, but don't wait long
+	revokeCtx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+	if revokeErr := vaultClient.RevokeTokens(revokeCtx); revokeErr != nil {
+		log.Warn(revokeErr)
+	}
+	cancel()
+
 	if err != nil {
 		log.Fatalf("ERROR: %s", err)
 	}
@@ -104,22 +303,116 @@ func newTalebearerConfig() (*talebearerConfig, error) {
 	case inputFile == "" && inPlace:
 		flags.Usage()
 		return nil, fmt.Errorf("input file must be specified")
+	case concurrency < 1:
+		return nil, fmt.Errorf("concurrency must be at least 1")
+	case retryWaitMin > retryWaitMax:
+		return nil, fmt.Errorf("-retry-wait-min must not be longer than -retry-wait-max")
+	case requestTimeout < 0 || timeout < 0:
+		return nil, fmt.Errorf("timeouts must not be negative")
+	case childTokenTTL < 0 || childTokenUses < 0:
+		return nil, fmt.Errorf("-child-token-ttl and -child-token-uses must not be negative")
+	case childTokenTTL > 0 && renewToken:
+		// Renewing would keep the child token alive past its TTL
+		return nil, fmt.Errorf("-renew-token can't be used with -child-token-ttl")
 	}
 
 	if inPlace {
 		outputFile = inputFile
 	}
 
+	var asOfTime time.Time
+	if asOf != "" {
+		var err error
+		asOfTime, err = time.Parse(time.RFC3339, asOf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -as-of time: %s", err)
+		}
+	}
+
+	tls, err := vaultConn.tls()
+	if err != nil {
+		return nil, err
+	}
+	versions, err := vaultConn.versions()
+	if err != nil {
+		return nil, err
+	}
+
 	return &talebearerConfig{
 		inputFile:  inputFile,
 		outputFile: outputFile,
-		vaultRole:  vaultRole,
+		vaultRole:  vaultConn.role,
+
+		authMethod: vaultConn.authMethod,
+		authParams: vaultConn.authParamsWithMount(),
+		format:     format,
+		structured: structured,
+		asOf:       asOfTime,
+
+		agentSinkFile: vaultConn.agentSinkFile,
+		tokenHelper:   vaultConn.tokenHelper,
+
+		childTokenTTL:  childTokenTTL,
+		childTokenUses: childTokenUses,
+		renewToken:     renewToken,
+
+		tls: tls,
+
+		mountVersions: versions,
+
+		retry: vault.RetryPolicy{
+			MaxRetries: maxRetries,
+			MinWait:    retryWaitMin,
+			MaxWait:    retryWaitMax,
+		},
+		requestTimeout: requestTimeout,
+		timeout:        timeout,
+
+		wildcardPrefix:    wildcardPrefix,
+		wildcardSeparator: wildcardSeparator,
+		wildcardKeyCase:   wildcardKeyCase,
+
+		concurrency: concurrency,
+
+		secretsFile:    secretsFile,
+		secretsKeyFile: secretsKeyFile,
 	}, nil
 }
 
-// Run - Main control function, has to decide whether to continue or exit at each step
-func Run(client vault.Vault, config *talebearerConfig) error {
+func newTemplate(config *talebearerConfig) (internal.Template, error) {
+	if config.structured {
+		template, err := internal.NewStructuredTemplateFile(config.inputFile, config.format)
+		if err != nil {
+			return nil, err
+		}
+		return template, nil
+	}
+
 	template, err := internal.NewTemplateFile(config.inputFile)
+	if err != nil {
+		return nil, err
+	}
+	if config.format != "" {
+		f, err := internal.FormatByName(config.format)
+		if err != nil {
+			return nil, err
+		}
+		template.SetFormat(f)
+	}
+	style, err := internal.NewWildcardStyle(
+		config.wildcardPrefix, config.wildcardSeparator, config.wildcardKeyCase,
+	)
+	if err != nil {
+		return nil, err
+	}
+	template.SetWildcardStyle(style)
+	return template, nil
+}
+
+// Run - Main control function, has to decide whether to continue or exit at each step
+// Reading secrets stops once ctx is done.
+func Run(ctx context.Context, client vault.Vault, config *talebearerConfig) error {
+	template, err := newTemplate(config)
 	if err != nil {
 		// Not really possible to continue without error here
 		return fmt.Errorf("failed creating template: %s", err)
@@ -130,21 +423,50 @@ func Run(client vault.Vault, config *talebearerConfig) error {
 		return fmt.Errorf("failed finding placeholders in template: %s", err)
 	}
 
-	err = client.Authenticate(config.vaultRole)
+	resolver := internal.NewSecretResolver(client, internal.NewSecret)
+	resolver.SetConcurrency(config.concurrency)
+	if config.secretsFile != "" {
+		key, err := backend.LoadKey(config.secretsKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed loading secrets file key: %s", err)
+		}
+		b, err := backend.NewEncryptedFileBackend(config.secretsFile, key)
+		if err != nil {
+			return err
+		}
+		resolver.RegisterBackend(backend.DefaultScheme, b)
+	}
+
+	if config.secretsFile == "" && internal.UsesScheme(placeholders, backend.DefaultScheme) {
+		err = client.Authenticate(ctx, config.vaultRole)
+		if err != nil {
+			msg := fmt.Sprintf("failed authenticating with Vault: %s", err)
+			if continueOnError {
+				log.Error(msg)
+			} else {
+				return fmt.Errorf("%s; exiting", msg)
+			}
+		}
+	} else {
+		log.Debugf("No placeholders read from Vault, not authenticating")
+	}
+
+	secrets, err := resolver.Resolve(ctx, placeholders)
 	if err != nil {
-		msg := fmt.Sprintf("failed authenticating with Vault: %s", err)
-		if continueOnError {
-			log.Error(msg)
+		msg := fmt.Sprintf("failed resolving secrets: %s", err)
+		if continueOnError && ctx.Err() == nil {
+			log.Errorf("%s; continuing", msg)
 		} else {
 			return fmt.Errorf("%s; exiting", msg)
 		}
 	}
 
-	secrets, err := internal.NewSecretResolver(client, internal.NewSecret).Resolve(placeholders)
+	err = internal.ApplyFilters(secrets)
 	if err != nil {
-		msg := fmt.Sprintf("failed resolving secrets: %s", err)
+		// Secrets whose filters failed have been cleared, so their placeholders are left as they are
+		msg := fmt.Sprintf("failed applying filters: %s", err)
 		if continueOnError {
-			log.Errorf("%s; continuing", msg)
+			log.Errorf("%s; leaving those placeholders unrendered and continuing", msg)
 		} else {
 			return fmt.Errorf("%s; exiting", msg)
 		}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/al4/talebearer/vault"
+	"github.com/al4/talebearer/vault/vaulttest"
 
 	"io/ioutil"
 
 	vaultApi "github.com/hashicorp/vault/api"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -44,18 +49,26 @@ func (suite *TaleBearerTestSuite) TestRunWhenInputFileDoesNotExist() {
 
 	suite.config.inputFile = "examples/nonexistingfile.in"
 
-	err := Run(nil, suite.config)
+	err := Run(context.Background(), nil, suite.config)
 	assert.Error(suite.T(), err)
 	assert.Contains(suite.T(), err.Error(), "failed creating template")
 	assert.Contains(suite.T(), err.Error(), suite.config.inputFile)
 }
 
+func (suite *TaleBearerTestSuite) TestRunWithUnknownFormat() {
+	suite.config.format = "toml"
+
+	err := Run(context.Background(), nil, suite.config)
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "unknown format")
+}
+
 func (suite *TaleBearerTestSuite) TestRunWhenVaultNotListening() {
 	mockClient := new(vault.MockClient)
 	suite.config.vaultRole = "ConnectionRefused"
 	mockClient.On("Authenticate", suite.config.vaultRole)
 
-	err := Run(mockClient, suite.config)
+	err := Run(context.Background(), mockClient, suite.config)
 	assert.Error(suite.T(), err)
 	assert.Contains(suite.T(), err.Error(), "failed authenticating with Vault:")
 	assert.Contains(suite.T(), err.Error(), "connection refused")
@@ -67,7 +80,7 @@ func (suite *TaleBearerTestSuite) TestRunWhenRoleIsInvalid() {
 	suite.config.vaultRole = "InvalidRole"
 	mockClient.On("Authenticate", suite.config.vaultRole)
 
-	err := Run(mockClient, suite.config)
+	err := Run(context.Background(), mockClient, suite.config)
 	assert.Error(suite.T(), err)
 	assert.Contains(suite.T(), err.Error(), "failed authenticating with Vault:")
 	assert.Contains(suite.T(), err.Error(), fmt.Sprintf("entry for role %s not found", suite.config.vaultRole))
@@ -81,7 +94,7 @@ func (suite *TaleBearerTestSuite) TestRunWhenReadingPropertiesFileWithSecrets()
 	mockClient.On("Authenticate", suite.config.vaultRole)
 	mockClient.On("Read", "secret/example")
 
-	err := Run(mockClient, suite.config)
+	err := Run(context.Background(), mockClient, suite.config)
 	assert.NoError(suite.T(), err)
 
 	actual, _ := ioutil.ReadFile(suite.config.outputFile)
@@ -98,7 +111,7 @@ func (suite *TaleBearerTestSuite) TestRunWhenWritingToInvalidOutputFile() {
 	mockClient.On("Authenticate", suite.config.vaultRole)
 	mockClient.On("Read", "secret/example")
 
-	err := Run(mockClient, suite.config)
+	err := Run(context.Background(), mockClient, suite.config)
 	assert.Error(suite.T(), err)
 	assert.Contains(suite.T(), err.Error(), "failed writing to file")
 	assert.Contains(suite.T(), err.Error(), suite.config.outputFile)
@@ -112,7 +125,7 @@ func (suite *TaleBearerTestSuite) TestRunWhenReadingGenericFileWithSecrets() {
 	mockClient.On("Authenticate", suite.config.vaultRole)
 	mockClient.On("Read", "secret/example")
 
-	err := Run(mockClient, suite.config)
+	err := Run(context.Background(), mockClient, suite.config)
 	assert.NoError(suite.T(), err)
 
 	actual, _ := ioutil.ReadFile(suite.config.outputFile)
@@ -131,7 +144,7 @@ func (suite *TaleBearerTestSuite) TestRunWhenSomeSecretsAreNotResolved() {
 	mockClient.On("Read", "secret/example")
 	mockClient.On("Read", "secret/invalid")
 
-	err := Run(mockClient, suite.config)
+	err := Run(context.Background(), mockClient, suite.config)
 	assert.Error(suite.T(), err)
 	assert.Contains(suite.T(), err.Error(), "failed resolving secrets")
 	assert.Contains(suite.T(), err.Error(), "secret data for path secret/invalid does not contain key invalid")
@@ -149,7 +162,7 @@ func (suite *TaleBearerTestSuite) TestRunWhenFatalIssueWhileResolving() {
 	mockClient.On("Read", "secret/example")
 	mockClient.On("Read", "secret/FATAL")
 
-	err := Run(mockClient, suite.config)
+	err := Run(context.Background(), mockClient, suite.config)
 	if err == nil {
 		suite.T().Error("error expected when called run")
 	}
@@ -160,6 +173,81 @@ func (suite *TaleBearerTestSuite) TestRunWhenFatalIssueWhileResolving() {
 	mockClient.AssertExpectations(suite.T())
 }
 
+func (suite *TaleBearerTestSuite) TestRunStructuredJSON() {
+	mockClient := new(vault.MockClient)
+	mockClient.ReturnSecret = &vaultApi.Secret{
+		Data: map[string]interface{}{
+			"password": "p\"ss",
+			"host":     "db.example.com",
+			"port":     json.Number("8080"),
+			"options":  map[string]interface{}{"ssl": true},
+		},
+	}
+	suite.config.inputFile = "examples/structured.json"
+	suite.config.structured = true
+	mockClient.On("Authenticate", suite.config.vaultRole)
+	mockClient.On("Read", "secret/example")
+
+	err := Run(context.Background(), mockClient, suite.config)
+	assert.NoError(suite.T(), err)
+
+	contents, _ := ioutil.ReadFile(suite.config.outputFile)
+	var rendered map[string]interface{}
+	assert.NoError(suite.T(), json.Unmarshal(contents, &rendered))
+	assert.Equal(suite.T(), "p\"ss", rendered["password"])
+	assert.Equal(suite.T(), float64(8080), rendered["port"])
+	assert.Equal(suite.T(), map[string]interface{}{"ssl": true}, rendered["database"].(map[string]interface{})["options"])
+	mockClient.AssertExpectations(suite.T())
+}
+
+func (suite *TaleBearerTestSuite) TestRunWildcard() {
+	mockClient := new(vault.MockClient)
+	mockClient.ReturnSecret = &vaultApi.Secret{
+		Data: map[string]interface{}{
+			"password":  "it's secret",
+			"db_host":   "db.example.com",
+			"cache_ttl": json.Number("300"),
+		},
+	}
+	suite.config.inputFile = "examples/wildcard.env"
+	suite.config.wildcardPrefix = "APP_"
+	suite.config.wildcardKeyCase = "upper"
+	mockClient.On("Authenticate", suite.config.vaultRole)
+	mockClient.On("Read", "secret/example")
+
+	err := Run(context.Background(), mockClient, suite.config)
+	assert.NoError(suite.T(), err)
+
+	actual, _ := ioutil.ReadFile(suite.config.outputFile)
+	expected := "# Every field of secret/example, one per line\n" +
+		"APP_CACHE_TTL=300\n" +
+		"APP_DB_HOST=db.example.com\n" +
+		"APP_PASSWORD='it'\\''s secret'\n" +
+		"LOG_LEVEL=info\n"
+	assert.Equal(suite.T(), expected, string(actual))
+	mockClient.AssertExpectations(suite.T())
+}
+
+func (suite *TaleBearerTestSuite) TestRunWithLocalBackends() {
+	// Authenticate isn't expected, as nothing is read from Vault
+	mockClient := new(vault.MockClient)
+	suite.config.inputFile = "examples/local.properties"
+	os.Setenv("TALEBEARER_EXAMPLE_PASSWORD", "pa=ss")
+	defer os.Unsetenv("TALEBEARER_EXAMPLE_PASSWORD")
+
+	err := Run(context.Background(), mockClient, suite.config)
+	assert.NoError(suite.T(), err)
+
+	actual, _ := ioutil.ReadFile(suite.config.outputFile)
+	expected := "# Secrets from the environment and local files, which need no Vault server\n" +
+		"db.user=app\n" +
+		"db.port=5432\n" +
+		"db.password=pa=ss\n" +
+		"api.token=tok3n\n"
+	assert.Equal(suite.T(), expected, string(actual))
+	mockClient.AssertExpectations(suite.T())
+}
+
 func (suite *TaleBearerTestSuite) TestRunCallsAuthenticate() {
 	mockClient := new(vault.MockClient)
 	mockClient.ReturnSecret = &mockSecret
@@ -167,12 +255,104 @@ func (suite *TaleBearerTestSuite) TestRunCallsAuthenticate() {
 	mockClient.On("Authenticate", suite.config.vaultRole)
 	mockClient.On("Read", "secret/example")
 
-	err := Run(mockClient, suite.config)
+	err := Run(context.Background(), mockClient, suite.config)
 	assert.NoError(suite.T(), err)
 
 	mockClient.AssertExpectations(suite.T())
 }
 
+// newFakeVaultClient - a fake Vault server seeded from examples/vault.yaml, and a client of it
+// which authenticates with a token unless there are opts
+func newFakeVaultClient(t *testing.T, readonly bool, opts ...vault.Option) (*vaulttest.Server, vault.Vault) {
+	srv := vaulttest.NewTestServer(t, "examples/vault.yaml")
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "ci-token")
+	if len(opts) > 0 {
+		t.Setenv("VAULT_TOKEN", "")
+		t.Setenv("HOME", t.TempDir())
+	}
+	client, err := vault.NewVaultClient(readonly, opts...)
+	require.NoError(t, err)
+	return srv, client
+}
+
+func (suite *TaleBearerTestSuite) TestRunWithFakeVault() {
+	suite.config.inputFile = "examples/file1.in"
+
+	_, client := newFakeVaultClient(suite.T(), true)
+
+	err := Run(context.Background(), client, suite.config)
+	assert.NoError(suite.T(), err)
+
+	actual, _ := ioutil.ReadFile(suite.config.outputFile)
+	expected, _ := ioutil.ReadFile("examples/file1.out")
+	assert.Equal(suite.T(), expected, actual)
+}
+
+func (suite *TaleBearerTestSuite) TestRunWithFakeVaultKVVersions() {
+	suite.config.inputFile = "examples/kv-versions.properties"
+
+	_, client := newFakeVaultClient(suite.T(), true)
+
+	err := Run(context.Background(), client, suite.config)
+	assert.NoError(suite.T(), err)
+
+	actual, _ := ioutil.ReadFile(suite.config.outputFile)
+	assert.Equal(suite.T(), "v2=value1\nv1=value1\n", string(actual))
+}
+
+func (suite *TaleBearerTestSuite) TestRunWithFakeVaultMissingSecret() {
+	suite.config.inputFile = "examples/file4.in"
+
+	_, client := newFakeVaultClient(suite.T(), true)
+
+	err := Run(context.Background(), client, suite.config)
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "failed resolving secrets")
+	assert.Contains(suite.T(), err.Error(), "secret/invalid")
+}
+
+func (suite *TaleBearerTestSuite) TestRunWithFakeVaultLogin() {
+	suite.config.inputFile = "examples/file1.in"
+	_, client := newFakeVaultClient(suite.T(), true, vault.WithAuthMethod("approle", map[string]string{
+		"role_id": "app-role-id", "secret_id": "app-secret-id",
+	}))
+
+	err := Run(context.Background(), client, suite.config)
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *TaleBearerTestSuite) TestRunWithFakeVaultLoginFailure() {
+	suite.config.inputFile = "examples/file1.in"
+	_, client := newFakeVaultClient(suite.T(), true, vault.WithAuthMethod("approle", map[string]string{
+		"role_id": "app-role-id", "secret_id": "wrong",
+	}))
+
+	err := Run(context.Background(), client, suite.config)
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "failed authenticating with Vault:")
+	assert.Contains(suite.T(), err.Error(), "invalid role or secret ID")
+	// Create an output file for TearDownTest to remove
+	_ = ioutil.WriteFile(suite.config.outputFile, nil, 0644)
+}
+
 func TestTaleBearerTestSuite(t *testing.T) {
 	suite.Run(t, new(TaleBearerTestSuite))
 }
+
+func TestNewTalebearerConfig_RenewChildToken(t *testing.T) {
+	defer func(input, output string, ttl time.Duration, renew bool) {
+		inputFile, outputFile, childTokenTTL, renewToken = input, output, ttl, renew
+	}(inputFile, outputFile, childTokenTTL, renewToken)
+	inputFile, outputFile = "examples/file1.in", "examples/output"
+	childTokenTTL, renewToken = 5*time.Minute, true
+
+	_, err := newTalebearerConfig()
+	if assert.Error(t, err) {
+		assert.Equal(t, "-renew-token can't be used with -child-token-ttl", err.Error())
+	}
+
+	childTokenTTL = 0
+	_, err = newTalebearerConfig()
+	assert.NoError(t, err)
+}
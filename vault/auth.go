@@ -0,0 +1,354 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	vaultApi "github.com/hashicorp/vault/api"
+	credAws "github.com/hashicorp/vault/builtin/credential/aws"
+)
+
+// DefaultAuthMethod - the auth method used unless another is given with WithAuthMethod
+const DefaultAuthMethod = "aws"
+
+// ServiceAccountTokenPath - where Kubernetes mounts a pod's service account token, which the
+// kubernetes auth method reads unless given another JWT
+const ServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// authMethods - constructors of the handler for each auth method, keyed by name
+// Each handler takes its parameters from the map passed to Auth, including "mount", the path
+// the auth method is mounted at, which defaults to the name of the method.
+var authMethods = map[string]func() authHandler{
+	"approle":    func() authHandler { return &appRoleHandler{} },
+	"aws":        func() authHandler { return &credAws.CLIHandler{} },
+	"cert":       func() authHandler { return &certHandler{} },
+	"jwt":        func() authHandler { return &jwtHandler{defaultMount: "jwt"} },
+	"kubernetes": newKubernetesHandler,
+	"token":      func() authHandler { return &tokenHandler{} },
+	"userpass":   func() authHandler { return &userpassHandler{} },
+}
+
+// AuthMethods - the sorted names of the auth methods which can be given to WithAuthMethod
+func AuthMethods() []string {
+	names := make([]string, 0, len(authMethods))
+	for name := range authMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithAuthMethod - authenticate with the named auth method, passing params to its handler
+func WithAuthMethod(method string, params map[string]string) Option {
+	return func(c *BaseClient) {
+		c.authMethod = method
+		c.authParams = params
+	}
+}
+
+// newAuthHandler - the handler for the named auth method
+func newAuthHandler(method string) (authHandler, error) {
+	newHandler, ok := authMethods[method]
+	if !ok {
+		return nil, fmt.Errorf("unknown auth method %q, valid methods are %v", method, AuthMethods())
+	}
+	return newHandler(), nil
+}
+
+// authParam - the parameter name in m, or if it isn't given, the trimmed contents of the file
+// named by the parameter name_file, or the environment variable named by name_env. Empty if none
+// of them is given.
+func authParam(m map[string]string, name string) (string, error) {
+	if v, ok := m[name]; ok {
+		return v, nil
+	}
+	if file := m[name+"_file"]; file != "" {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed reading %s: %s", name, err)
+		}
+		return strings.TrimSpace(string(contents)), nil
+	}
+	if env := m[name+"_env"]; env != "" {
+		v, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("failed reading %s: environment variable %s is not set", name, env)
+		}
+		return strings.TrimSpace(v), nil
+	}
+	return "", nil
+}
+
+// requiredAuthParam - as authParam, but it is an error if the parameter isn't given
+func requiredAuthParam(m map[string]string, name string) (string, error) {
+	v, err := authParam(m, name)
+	if err != nil {
+		return "", err
+	}
+	if v == "" {
+		return "", fmt.Errorf("one of '%s', '%s_file' or '%s_env' must be specified", name, name, name)
+	}
+	return v, nil
+}
+
+// authMount - the mount parameter in m, or defaultMount if it isn't given
+func authMount(m map[string]string, defaultMount string) string {
+	if mount := sanitisePath(m["mount"]); mount != "" {
+		return mount
+	}
+	return defaultMount
+}
+
+// login - log in at path with data, the same way as the Vault CLI's handlers
+func login(ctx context.Context, c *vaultApi.Client, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	secret, err := writeWithContext(ctx, c, path, data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("empty response from credential provider")
+	}
+	return secret, nil
+}
+
+// tokenHandler - uses a token which was issued elsewhere
+type tokenHandler struct{}
+
+// Auth - the token given, which is verified by looking it up once it is set
+func (h *tokenHandler) Auth(c *vaultApi.Client, m map[string]string) (*vaultApi.Secret, error) {
+	token, err := requiredAuthParam(m, "token")
+	if err != nil {
+		return nil, err
+	}
+	return &vaultApi.Secret{Auth: &vaultApi.SecretAuth{ClientToken: token}}, nil
+}
+
+// Help - parameters of the token auth method
+func (h *tokenHandler) Help() string {
+	return strings.TrimSpace(`
+Uses a token issued elsewhere, rather than logging in.
+
+  token=<string>    The token, which may instead be read from a file with token_file=<path>,
+                    or from an environment variable with token_env=<variable>
+`)
+}
+
+// appRoleHandler - logs in with an AppRole role ID and secret ID
+// The secret ID may be response-wrapped, as CI systems often deliver it, in which case it is
+// unwrapped first.
+type appRoleHandler struct{}
+
+// Auth - as AuthWithContext, without a deadline
+func (h *appRoleHandler) Auth(c *vaultApi.Client, m map[string]string) (*vaultApi.Secret, error) {
+	return h.AuthWithContext(context.Background(), c, m)
+}
+
+// AuthWithContext - log in at auth/<mount>/login
+func (h *appRoleHandler) AuthWithContext(ctx context.Context, c *vaultApi.Client, m map[string]string) (*vaultApi.Secret, error) {
+	mount := authMount(m, "approle")
+	roleID, err := requiredAuthParam(m, "role_id")
+	if err != nil {
+		return nil, err
+	}
+	secretID, err := authParam(m, "secret_id")
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := authParam(m, "wrapped_secret_id")
+	if err != nil {
+		return nil, err
+	}
+	if secretID != "" && wrapped != "" {
+		return nil, fmt.Errorf("only one of a secret ID and a wrapped secret ID may be given")
+	}
+	if wrapped != "" {
+		secretID, err = unwrapSecretID(ctx, c, mount, wrapped)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data := map[string]interface{}{"role_id": roleID}
+	if secretID != "" {
+		data["secret_id"] = secretID
+	}
+	return login(ctx, c, fmt.Sprintf("auth/%s/login", mount), data)
+}
+
+// unwrapSecretID - unwrap a response-wrapped AppRole secret ID, after checking it was wrapped
+// by generating a secret ID on the given mount, so that a token wrapping anything else isn't
+// used. Each wrapping token can only be unwrapped once.
+func unwrapSecretID(ctx context.Context, c *vaultApi.Client, mount string, wrapped string) (string, error) {
+	info, err := writeWithContext(ctx, c, "sys/wrapping/lookup", map[string]interface{}{"token": wrapped})
+	if err != nil {
+		return "", fmt.Errorf("failed looking up wrapped secret ID: %s", err)
+	}
+	if info == nil || info.Data == nil {
+		return "", fmt.Errorf("failed looking up wrapped secret ID, no data was returned")
+	}
+	creationPath, _ := info.Data["creation_path"].(string)
+	if !strings.HasPrefix(creationPath, "auth/"+mount+"/role/") ||
+		!strings.HasSuffix(creationPath, "/secret-id") {
+		return "", fmt.Errorf("wrapped secret ID was created at %q, not by generating a secret ID "+
+			"on auth/%s, so may have been tampered with", creationPath, mount)
+	}
+
+	secret, err := unwrapWithContext(ctx, c, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("failed unwrapping secret ID: %s", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("failed unwrapping secret ID, no data was returned")
+	}
+	secretID, _ := secret.Data["secret_id"].(string)
+	if secretID == "" {
+		return "", fmt.Errorf("wrapped response did not contain a secret ID")
+	}
+	return secretID, nil
+}
+
+// Help - parameters of the AppRole auth method
+func (h *appRoleHandler) Help() string {
+	return strings.TrimSpace(`
+Logs in with an AppRole role ID and secret ID.
+
+  mount=<string>                The path the auth method is mounted at, default approle
+  role_id=<string>              The role ID
+  secret_id=<string>            The secret ID, if the role requires one
+  wrapped_secret_id=<string>    A response-wrapping token for the secret ID, instead of it
+
+Each may instead be read from a file with <name>_file=<path>, or from an environment variable
+with <name>_env=<variable>.
+`)
+}
+
+// jwtHandler - logs in with a JWT and a role, as the jwt and kubernetes auth methods do
+type jwtHandler struct {
+	defaultMount   string
+	defaultJWTFile string // Read if no JWT is given, e.g. a service account token
+}
+
+// newKubernetesHandler - logs in with the pod's service account token, unless given a JWT
+func newKubernetesHandler() authHandler {
+	return &jwtHandler{defaultMount: "kubernetes", defaultJWTFile: ServiceAccountTokenPath}
+}
+
+// Auth - as AuthWithContext, without a deadline
+func (h *jwtHandler) Auth(c *vaultApi.Client, m map[string]string) (*vaultApi.Secret, error) {
+	return h.AuthWithContext(context.Background(), c, m)
+}
+
+// AuthWithContext - log in at auth/<mount>/login
+func (h *jwtHandler) AuthWithContext(ctx context.Context, c *vaultApi.Client, m map[string]string) (*vaultApi.Secret, error) {
+	jwt, err := authParam(m, "jwt")
+	if err != nil {
+		return nil, err
+	}
+	if jwt == "" && h.defaultJWTFile != "" {
+		jwt, err = authParam(map[string]string{"jwt_file": h.defaultJWTFile}, "jwt")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if jwt == "" {
+		return nil, fmt.Errorf("one of 'jwt', 'jwt_file' or 'jwt_env' must be specified")
+	}
+	role := m["role"]
+	if role == "" {
+		return nil, fmt.Errorf("'role' must be specified")
+	}
+	return login(ctx, c, fmt.Sprintf("auth/%s/login", authMount(m, h.defaultMount)), map[string]interface{}{
+		"jwt":  jwt,
+		"role": role,
+	})
+}
+
+// Help - parameters of the JWT auth methods
+func (h *jwtHandler) Help() string {
+	help := fmt.Sprintf(`
+Logs in with a JWT, such as a Kubernetes service account token, as a role.
+
+  mount=<string>    The path the auth method is mounted at, default %s
+  role=<string>     The role to log in as, which -role also sets
+  jwt=<string>      The JWT, which may instead be read from a file with jwt_file=<path>, or
+                    from an environment variable with jwt_env=<variable>
+`, h.defaultMount)
+	if h.defaultJWTFile != "" {
+		help += fmt.Sprintf("\nIf no JWT is given, it is read from %s.\n", h.defaultJWTFile)
+	}
+	return strings.TrimSpace(help)
+}
+
+// certHandler - logs in with the client certificate given by WithTLS or VAULT_CLIENT_CERT
+type certHandler struct{}
+
+// Auth - as AuthWithContext, without a deadline
+func (h *certHandler) Auth(c *vaultApi.Client, m map[string]string) (*vaultApi.Secret, error) {
+	return h.AuthWithContext(context.Background(), c, m)
+}
+
+// AuthWithContext - log in at auth/<mount>/login, as the named certificate role if one is
+// given, otherwise as whichever role matches the client certificate
+func (h *certHandler) AuthWithContext(ctx context.Context, c *vaultApi.Client, m map[string]string) (*vaultApi.Secret, error) {
+	name := m["name"]
+	if name == "" {
+		name = m["role"]
+	}
+	data := map[string]interface{}{}
+	if name != "" {
+		data["name"] = name
+	}
+	return login(ctx, c, fmt.Sprintf("auth/%s/login", authMount(m, "cert")), data)
+}
+
+// Help - parameters of the cert auth method
+func (h *certHandler) Help() string {
+	return strings.TrimSpace(`
+Logs in with the client certificate presented when connecting to Vault, which is given with
+-tls-client-cert and -tls-client-key, or VAULT_CLIENT_CERT and VAULT_CLIENT_KEY.
+
+  mount=<string>    The path the auth method is mounted at, default cert
+  name=<string>     The certificate role to log in as, which -role also sets. By default,
+                    whichever role matches the certificate
+`)
+}
+
+// userpassHandler - logs in with a username and password
+// Unlike the Vault CLI's handler, it never prompts for the password.
+type userpassHandler struct{}
+
+// Auth - as AuthWithContext, without a deadline
+func (h *userpassHandler) Auth(c *vaultApi.Client, m map[string]string) (*vaultApi.Secret, error) {
+	return h.AuthWithContext(context.Background(), c, m)
+}
+
+// AuthWithContext - log in at auth/<mount>/login/<username>
+func (h *userpassHandler) AuthWithContext(ctx context.Context, c *vaultApi.Client, m map[string]string) (*vaultApi.Secret, error) {
+	username := m["username"]
+	if username == "" {
+		return nil, fmt.Errorf("'username' must be specified")
+	}
+	password, err := requiredAuthParam(m, "password")
+	if err != nil {
+		return nil, err
+	}
+	path := fmt.Sprintf("auth/%s/login/%s", authMount(m, "userpass"), username)
+	return login(ctx, c, path, map[string]interface{}{"password": password})
+}
+
+// Help - parameters of the userpass auth method
+func (h *userpassHandler) Help() string {
+	return strings.TrimSpace(`
+Logs in with a username and password.
+
+  mount=<string>       The path the auth method is mounted at, default userpass
+  username=<string>    The username
+  password=<string>    The password, which may instead be read from a file with
+                       password_file=<path>, or from an environment variable with
+                       password_env=<variable>
+`)
+}
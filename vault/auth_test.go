@@ -0,0 +1,301 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/al4/talebearer/vault/vaulttest"
+)
+
+func TestNewVaultClient_UnknownAuthMethod(t *testing.T) {
+	_, err := NewVaultClient(true, WithAuthMethod("nope", nil))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	expected := `unknown auth method "nope", valid methods are ` +
+		`[approle aws cert jwt kubernetes token userpass]`
+	if err.Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, err)
+	}
+}
+
+func TestBaseClient_Authenticate_PassesParams(t *testing.T) {
+	returnSecret := &vaultApi.Secret{
+		Auth: &vaultApi.SecretAuth{ClientToken: "devToken"},
+	}
+	vaultClient, err := generateVaultClient(returnSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &mockHandler{ReturnSecret: returnSecret}
+	handler.On("Auth", vaultClient, map[string]string{"role": "fromParams", "mount": "ci"})
+	client := &BaseClient{
+		client:      vaultClient,
+		authHandler: handler,
+		authParams:  map[string]string{"role": "fromParams", "mount": "ci"},
+		logger:      log.WithField("test", true),
+	}
+
+	if err := client.Authenticate(context.Background(), "fromFlag"); err != nil {
+		t.Fatal(err)
+	}
+	handler.AssertExpectations(t)
+}
+
+func TestAuthHandlers(t *testing.T) {
+	dir := t.TempDir()
+	secretIDFile := filepath.Join(dir, "secret-id")
+	if err := ioutil.WriteFile(secretIDFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		method       string
+		params       map[string]string
+		expectedPath string
+		expectedBody map[string]interface{}
+	}{
+		{
+			method:       "approle",
+			params:       map[string]string{"role_id": "app", "secret_id_file": secretIDFile},
+			expectedPath: "/v1/auth/approle/login",
+			expectedBody: map[string]interface{}{"role_id": "app", "secret_id": "s3cret"},
+		},
+		{
+			method:       "kubernetes",
+			params:       map[string]string{"role": "app", "jwt": "eyJ", "mount": "/k8s/prod/"},
+			expectedPath: "/v1/auth/k8s/prod/login",
+			expectedBody: map[string]interface{}{"role": "app", "jwt": "eyJ"},
+		},
+		{
+			method:       "jwt",
+			params:       map[string]string{"role": "app", "jwt": "eyJ"},
+			expectedPath: "/v1/auth/jwt/login",
+			expectedBody: map[string]interface{}{"role": "app", "jwt": "eyJ"},
+		},
+		{
+			method:       "cert",
+			params:       map[string]string{"role": "web"},
+			expectedPath: "/v1/auth/cert/login",
+			expectedBody: map[string]interface{}{"name": "web"},
+		},
+		{
+			method:       "userpass",
+			params:       map[string]string{"username": "ci", "password": "hunter2"},
+			expectedPath: "/v1/auth/userpass/login/ci",
+			expectedBody: map[string]interface{}{"password": "hunter2"},
+		},
+	}
+	for _, tc := range testCases {
+		rt := &roundTripper{ReturnResponseSecret: &vaultApi.Secret{
+			Auth: &vaultApi.SecretAuth{ClientToken: "devToken"},
+		}}
+		vaultClient, err := generateVaultClientWithTransport(rt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler, err := newAuthHandler(tc.method)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		secret, err := handler.Auth(vaultClient, tc.params)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.method, err)
+			continue
+		}
+		if secret.Auth.ClientToken != "devToken" {
+			t.Errorf("%s: unexpected token %q", tc.method, secret.Auth.ClientToken)
+		}
+		if len(rt.Requests) != 1 || rt.Requests[0] != tc.expectedPath {
+			t.Errorf("%s: expected a request to %s, got %v", tc.method, tc.expectedPath, rt.Requests)
+			continue
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal([]byte(rt.Bodies[0]), &body); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tc.expectedBody, body) {
+			t.Errorf("%s: expected body %v, got %v", tc.method, tc.expectedBody, body)
+		}
+	}
+}
+
+func TestAuthHandlers_MissingParams(t *testing.T) {
+	for method, expected := range map[string]string{
+		"approle":  "one of 'role_id', 'role_id_file' or 'role_id_env' must be specified",
+		"jwt":      "one of 'jwt', 'jwt_file' or 'jwt_env' must be specified",
+		"token":    "one of 'token', 'token_file' or 'token_env' must be specified",
+		"userpass": "'username' must be specified",
+	} {
+		handler, err := newAuthHandler(method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = handler.Auth(nil, map[string]string{})
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s: expected error %q, got %v", method, expected, err)
+		}
+	}
+}
+
+func TestAppRoleHandler_WrappedSecretID(t *testing.T) {
+	t.Setenv("TALEBEARER_TEST_WRAPPED", "wrapped-secret-id")
+	srv, client := newFakeVault(t, true, WithAuthMethod("approle", map[string]string{
+		"role_id":               "app-role-id",
+		"wrapped_secret_id_env": "TALEBEARER_TEST_WRAPPED",
+	}))
+	if err := client.Authenticate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if token := client.client.Token(); token == "" || token == vaulttest.RootToken {
+		t.Errorf("expected a token from the approle login, got %q", token)
+	}
+	expected := []string{
+		"PUT sys/wrapping/lookup",
+		"PUT sys/wrapping/unwrap",
+		"PUT auth/approle/login",
+	}
+	if requests := srv.Requests(); !reflect.DeepEqual(expected, requests[:len(expected)]) {
+		t.Errorf("expected requests to start %v, got %v", expected, requests)
+	}
+
+	// A wrapping token can only be unwrapped once
+	client.client.ClearToken()
+	_, err := (&appRoleHandler{}).Auth(client.client, map[string]string{
+		"role_id":           "app-role-id",
+		"wrapped_secret_id": "wrapped-secret-id",
+	})
+	if err == nil || !strings.Contains(err.Error(), "wrapping token is not valid") {
+		t.Errorf("expected an invalid wrapping token error, got %v", err)
+	}
+}
+
+func TestAppRoleHandler_WrappedElsewhere(t *testing.T) {
+	_, client := newFakeVault(t, true)
+	client.client.ClearToken()
+
+	_, err := (&appRoleHandler{}).Auth(client.client, map[string]string{
+		"role_id":           "app-role-id",
+		"wrapped_secret_id": "wrapped-elsewhere",
+	})
+	expected := `wrapped secret ID was created at "secret/data/app", not by generating a ` +
+		`secret ID on auth/approle, so may have been tampered with`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestKubernetesHandler_ServiceAccountToken(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("eyJ.sa.token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var logins []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/k8s/prod/login":
+			var body map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			logins = append(logins, body)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": "podToken"},
+			})
+		case "/v1/auth/token/lookup-self":
+			if r.Header.Get("X-Vault-Token") != "podToken" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"id": "podToken"},
+			})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	config := vaultApi.DefaultConfig()
+	config.Address = srv.URL
+	vaultClient, err := vaultApi.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultClient.ClearToken()
+	handler, err := newAuthHandler("kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.(*jwtHandler).defaultJWTFile = tokenFile
+	client := &BaseClient{
+		client:      vaultClient,
+		authHandler: handler,
+		authParams:  map[string]string{"mount": "k8s/prod"},
+		logger:      log.WithField("test", true),
+	}
+
+	if err := client.Authenticate(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	if vaultClient.Token() != "podToken" {
+		t.Errorf("unexpected token %q", vaultClient.Token())
+	}
+	expected := []map[string]interface{}{{"jwt": "eyJ.sa.token", "role": "app"}}
+	if !reflect.DeepEqual(expected, logins) {
+		t.Errorf("expected logins %v, got %v", expected, logins)
+	}
+}
+
+func TestKubernetesHandler_MissingServiceAccountToken(t *testing.T) {
+	handler := &jwtHandler{defaultMount: "kubernetes", defaultJWTFile: "/nonexistent/token"}
+	_, err := handler.Auth(nil, map[string]string{"role": "app"})
+	if err == nil || !strings.HasPrefix(err.Error(), "failed reading jwt: ") {
+		t.Errorf("expected an error reading the token, got %v", err)
+	}
+}
+
+func TestBaseClient_Authenticate_StopsWhenContextDone(t *testing.T) {
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer s.Close()
+	defer close(release)
+
+	config := vaultApi.DefaultConfig()
+	config.Address = s.URL
+	vaultClient, err := vaultApi.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultClient.ClearToken()
+	client := &BaseClient{
+		client:      vaultClient,
+		authHandler: &userpassHandler{},
+		authMethod:  "userpass",
+		authParams:  map[string]string{"username": "app", "password": "s3cret"},
+		logger:      log.WithField("test", true),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = client.Authenticate(ctx, "")
+	if err == nil || !strings.Contains(err.Error(), "context deadline exceeded") {
+		t.Errorf("expected the login to stop at the deadline, got %v", err)
+	}
+}
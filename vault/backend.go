@@ -0,0 +1,96 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/al4/talebearer/backend"
+)
+
+// ReadDocument - read the fields of the document at path, whichever KV API version it is stored
+// with, at the given version if not 0
+func (c *BaseClient) ReadDocument(ctx context.Context, path string, version int) (map[string]interface{}, error) {
+	return readDocument(ctx, c, path, version)
+}
+
+// ListDocuments - list the documents directly under path, whichever KV API version it is
+// stored with
+func (c *BaseClient) ListDocuments(ctx context.Context, path string) ([]string, error) {
+	mount, mountVersion, err := c.mounts.mount(ctx, c.client, path)
+	if err != nil {
+		return nil, err
+	}
+	p := path
+	if mountVersion == 2 {
+		p = metadataPath(mount, path)
+	}
+	return listDocuments(ctx, c, p)
+}
+
+// Capabilities - KV v2 mounts keep versions, and both KV API versions can list documents
+func (c *BaseClient) Capabilities() backend.Capabilities {
+	return backend.Capabilities{Versions: true, List: true}
+}
+
+// readDocument - read a document with the read methods of v, and return its fields
+func readDocument(ctx context.Context, v readMethods, path string, version int) (map[string]interface{}, error) {
+	var secret *vaultApi.Secret
+	var err error
+	if version > 0 {
+		secret, err = v.ReadVersion(ctx, path, version)
+	} else {
+		secret, err = v.Read(ctx, path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch secret '%s' from Vault: %s", path, err)
+	}
+
+	if secret == nil {
+		return nil, fmt.Errorf("failed to fetch secret '%s' from Vault, secret was nil", path)
+	}
+
+	if secret.Data == nil {
+		return nil, fmt.Errorf("failed to fetch secret '%s' from Vault, secret.Data was nil", path)
+	}
+
+	// Could do with some more sanity-checking here
+	if val, ok := secret.Data["data"]; ok { // KV API v2
+		// Let's not make any KV API v1 secrets called "data", OK?
+		d, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("could not parse KV v2 secret data: %v", val)
+		}
+		log.Debugf("Read %s (KV API v2)", path)
+		return d, nil
+	}
+	log.Debugf("Read %s (KV API v1)", path)
+	return secret.Data, nil
+}
+
+// listDocuments - list the documents at path with the read methods of v
+func listDocuments(ctx context.Context, v readMethods, path string) ([]string, error) {
+	secret, err := v.List(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list '%s' in Vault: %s", path, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+	keys, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("could not parse keys listed at '%s': %v", path, secret.Data["keys"])
+	}
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		name, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("could not parse key listed at '%s': %v", path, k)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
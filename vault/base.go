@@ -1,66 +1,122 @@
 package vault
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	vaultApi "github.com/hashicorp/vault/api"
-	credAws "github.com/hashicorp/vault/builtin/credential/aws"
+
+	"github.com/al4/talebearer/backend"
 )
 
 // Vault - an abstraction of hashicorp's vault api client
 // With the exception of Authenticate, most functions in this file are simple pass-through calls
-// to the vault API, which don't do anything special.
+// to the vault API, which don't do anything special. Those taking a context stop waiting for
+// Vault, including between retries, once it is done.
 type Vault interface {
+	backend.Backend
 	readMethods
 	writeMethods
-	Authenticate(string) error
+	Authenticate(ctx context.Context, role string) error
+	MountVersion(ctx context.Context, path string) (int, error)
+	RevokeTokens(ctx context.Context) error
+	SecretPath(ctx context.Context, path string) (string, error)
+	MetadataPath(ctx context.Context, path string) (string, error)
 }
 
 type readMethods interface {
 	GetPolicy(name string) (string, error)
-	List(path string) (*vaultApi.Secret, error)
+	List(ctx context.Context, path string) (*vaultApi.Secret, error)
 	ListAuth() (map[string]*vaultApi.AuthMount, error)
 	ListPolicies() ([]string, error)
-	Read(path string) (*vaultApi.Secret, error)
+	Read(ctx context.Context, path string) (*vaultApi.Secret, error)
+	ReadVersion(ctx context.Context, path string, version int) (*vaultApi.Secret, error)
 }
 
 type writeMethods interface {
-	Delete(path string) (*vaultApi.Secret, error)
+	Delete(ctx context.Context, path string) (*vaultApi.Secret, error)
 	DeletePolicy(name string) error
 	DisableAuth(string) error
 	EnableAuth(path string, options *vaultApi.EnableAuthOptions) error
 	PutPolicy(string, string) error
-	Write(path string, data map[string]interface{}) (*vaultApi.Secret, error)
+	Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error)
 }
 
 // authHandler - handles Vault authentication
-// in AWS scenarios, vault/builtin/credential/aws/CLIHandler would normally be used
+// in AWS scenarios, vault/builtin/credential/aws/CLIHandler would normally be used, and
+// authMethods has a handler for each other auth method
 type authHandler interface {
 	Auth(c *vaultApi.Client, m map[string]string) (*vaultApi.Secret, error)
 	Help() string
 }
 
+// contextAuthHandler - an authHandler whose requests stop when ctx is done
+// Every handler in authMethods is one, except Vault's aws CLIHandler.
+type contextAuthHandler interface {
+	authHandler
+	AuthWithContext(ctx context.Context, c *vaultApi.Client, m map[string]string) (*vaultApi.Secret, error)
+}
+
 // BaseClient - The base vault client with common read/write methods
 type BaseClient struct {
 	readMethods
 	writeMethods
 	client      *vaultApi.Client
 	authHandler authHandler
+	authMethod  string            // Name of the auth method, used to pick authHandler
+	authParams  map[string]string // Passed to authHandler, along with the role
+	sink        *tokenSink        // Vault Agent sink to read the token from, if any
+	tokenHelper string            // Executable to get the token from, if any
+	tokenFile   string            // Usually ~/.vault-token, read if there is no other token
+	tls         *TLSConfig        // Applied once the options are, if given
+	childTTL    time.Duration     // Of the child token used for the render, see WithChildToken
+	childUses   int
+	renew       bool               // Keep renewing the token once authenticated
+	stopRenewal context.CancelFunc // Set once renewing
+	ownToken    string             // The first token talebearer created, revoked by RevokeTokens
 	logger      *log.Entry
+	asOf        time.Time // If set, KV v2 reads return the versions current at this time
+	mounts      *mountTable
+}
+
+// Option - configures optional behaviour of a BaseClient
+type Option func(c *BaseClient)
+
+// WithAsOf - read KV v2 secrets as they were at the given time, using the created_time of each
+// version in the secret's metadata
+func WithAsOf(t time.Time) Option {
+	return func(c *BaseClient) {
+		c.asOf = t
+	}
+}
+
+// WithMountVersions - declare the KV API version of mounts, keyed by mount path, so that they
+// are never looked up. Needed when the token may not read sys/internal/ui/mounts.
+func WithMountVersions(versions map[string]int) Option {
+	return func(c *BaseClient) {
+		for mount, version := range versions {
+			c.mounts.declare(mount, version)
+		}
+		c.logger.Debugf("Declared KV API versions of mounts %v", mountNames(versions))
+	}
 }
 
 // NewVaultClient - create a vault client
-func NewVaultClient(readonly bool) (c Vault, err error) {
+func NewVaultClient(readonly bool, opts ...Option) (c Vault, err error) {
 	config := vaultApi.DefaultConfig()
 	vaultAPIClient, err := vaultApi.NewClient(config)
 	if err != nil {
 		return c, err
 	}
 	logger := log.WithFields(log.Fields{"readonly": readonly})
+	mounts := newMountTable()
 
 	var writer writeMethods
 	if readonly {
@@ -71,26 +127,52 @@ func NewVaultClient(readonly bool) (c Vault, err error) {
 		writer = &writeClient{
 			logger: logger,
 			client: vaultAPIClient,
+			mounts: mounts,
 		}
 	}
-	return &BaseClient{
+	client := &BaseClient{
 		writeMethods: writer,
 		client:       vaultAPIClient,
-		authHandler:  &credAws.CLIHandler{},
+		authMethod:   DefaultAuthMethod,
+		tokenFile:    homeTokenFile(),
 		logger:       logger,
-	}, nil
+		mounts:       mounts,
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	// The API client shares config's HTTP client, and makes no requests until it is used
+	if client.tls != nil {
+		if err := client.tls.configure(config); err != nil {
+			return nil, fmt.Errorf("failed configuring TLS: %s", err)
+		}
+	}
+	client.authHandler, err = newAuthHandler(client.authMethod)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
 }
 
 // updatePath - insert "data" into the path after the mount
-func updatePath(path string) (p string) {
-	// Currently doesn't do any sanity checking
-	s := sanitisePath(path)
-	a := strings.Split(s, "/")
-	mount := a[0]
-	ap := a[1:]
-	out := []string{mount, "data"}
-	out = append(out, ap...)
+func updatePath(mount, path string) (p string) {
+	return insertPathElement(mount, path, "data")
+}
 
+// metadataPath - insert "metadata" into the path after the mount
+func metadataPath(mount, path string) string {
+	return insertPathElement(mount, path, "metadata")
+}
+
+// insertPathElement - insert an element into the path after the mount, which may have more than
+// one segment, e.g. team/kv
+func insertPathElement(mount, path string, element string) string {
+	m := sanitisePath(mount)
+	rest := strings.TrimPrefix(sanitisePath(path), m)
+	out := []string{m, element}
+	if rest = strings.Trim(rest, "/"); rest != "" {
+		out = append(out, rest)
+	}
 	return strings.Join(out, "/")
 }
 
@@ -101,9 +183,9 @@ func sanitisePath(path string) string {
 	return p
 }
 
-// getMountVersion - determine the version of the KV backend API
+// getMountVersion - determine the mount path is on and the version of its KV backend API
 // based heavily on vault.command.kvPreflightVersionRequest, a private method
-func getMountVersion(client *vaultApi.Client, path string) (int, error) {
+func getMountVersion(ctx context.Context, client *vaultApi.Client, path string) (string, int, error) {
 	currentWrappingLookupFunc := client.CurrentWrappingLookupFunc()
 	client.SetWrappingLookupFunc(nil)
 	defer client.SetWrappingLookupFunc(currentWrappingLookupFunc)
@@ -112,45 +194,46 @@ func getMountVersion(client *vaultApi.Client, path string) (int, error) {
 	defer client.SetOutputCurlString(currentOutputCurlString)
 
 	r := client.NewRequest("GET", "/v1/sys/internal/ui/mounts/"+path)
-	resp, err := client.RawRequest(r)
+	resp, err := client.RawRequestWithContext(ctx, r)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
 	if err != nil {
 		// If we get a 404 we are using an older version of vault, which means api v1
 		if resp != nil && resp.StatusCode == 404 {
-			return 1, nil
+			return "", 1, nil
 		}
-		return 0, err
+		return "", 0, err
 	}
 	// Parse mount path and kv api version from the returned secret
 	secret, err := vaultApi.ParseSecret(resp.Body)
 	if err != nil {
-		return 0, err
+		return "", 0, err
 	}
+	mount, _ := secret.Data["path"].(string)
 	options := secret.Data["options"]
 	if options == nil {
-		return 1, nil
+		return mount, 1, nil
 	}
 	versionRaw := options.(map[string]interface{})["version"]
 	if versionRaw == nil {
-		return 1, nil
+		return mount, 1, nil
 	}
 	version := versionRaw.(string)
 	switch version {
 	case "", "1":
-		return 1, nil
+		return mount, 1, nil
 	case "2":
-		return 2, nil
+		return mount, 2, nil
 	}
-	return 0, fmt.Errorf("unknown KV API version %v", version)
+	return "", 0, fmt.Errorf("unknown KV API version %v", version)
 }
 
 // pathToSecret - Determine the secret path
 // Talebearer secrets are traditionally given in KV API v1 format, v2 needs an extra "data" element
 // inserted
-func pathToSecret(client *vaultApi.Client, path string) (string, error) {
-	version, err := getMountVersion(client, path)
+func pathToSecret(ctx context.Context, client *vaultApi.Client, mounts *mountTable, path string) (string, error) {
+	mount, version, err := mounts.mount(ctx, client, path)
 	if err != nil {
 		return "", err
 	}
@@ -158,32 +241,91 @@ func pathToSecret(client *vaultApi.Client, path string) (string, error) {
 	case 1:
 		return path, nil
 	case 2:
-		return updatePath(path), nil
+		return updatePath(mount, path), nil
 	}
 	return "", fmt.Errorf("unsupported KV API version: %v", version)
 }
 
-// Authenticate - authenticate to Vault using official client methods
-func (c *BaseClient) Authenticate(role string) error {
+// Authenticate - authenticate to Vault using official client methods, unless there is already
+// a token, see existingToken. Then create a child token and start renewing the token, if the
+// client was configured to.
+// The auth handler's requests can't be cancelled, but are retried and time out like any other.
+func (c *BaseClient) Authenticate(ctx context.Context, role string) error {
+	if err := c.authenticate(ctx, role); err != nil {
+		return err
+	}
+	if c.childTTL > 0 || c.childUses > 0 {
+		if err := c.createChildToken(ctx); err != nil {
+			return err
+		}
+	}
+	if c.renew {
+		return c.startRenewal(ctx)
+	}
+	return nil
+}
+
+// authenticate - use the existing token, or log in
+func (c *BaseClient) authenticate(ctx context.Context, role string) error {
 	if c.client.Token() != "" {
 		// Already authenticated. Supposedly.
-		c.logger.Debugf("Already authenticated by environment variable")
+		c.logger.Debugf("Already authenticated by environment variable VAULT_TOKEN")
+		c.sink = nil
 		return nil
 	}
 
-	log.Debugf("Authenticating with Vault...")
-	secret, err := c.authHandler.Auth(c.client, map[string]string{"role": role})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	token, source, err := c.existingToken()
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		c.client.SetToken(token)
+		_, err := lookupSelfWithContext(ctx, c.client)
+		switch {
+		case err == nil:
+			c.logger.Debugf("Using the token from %s", source)
+			return nil
+		case source != c.tokenFile:
+			return fmt.Errorf("token from %s is not valid: %s", source, err)
+		}
+		// ~/.vault-token may well be left over from another login, so isn't worth failing for
+		c.logger.Warnf("Token from %s is not valid, logging in instead: %s", source, err)
+		c.client.ClearToken()
+	}
+
+	params := map[string]string{}
+	for k, v := range c.authParams {
+		params[k] = v
+	}
+	if _, ok := params["role"]; !ok && role != "" {
+		params["role"] = role
+	}
+
+	c.logger.Debugf("Authenticating with Vault using the %s auth method...", c.authMethod)
+	var secret *vaultApi.Secret
+	if h, ok := c.authHandler.(contextAuthHandler); ok {
+		secret, err = h.AuthWithContext(ctx, c.client, params)
+	} else {
+		secret, err = c.authHandler.Auth(c.client, params)
+	}
 	if err != nil {
 		return err
 	}
 
-	if secret == nil {
+	if secret == nil || secret.Auth == nil {
 		return errors.New("no secret returned from Vault")
 	}
 
 	c.client.SetToken(secret.Auth.ClientToken)
+	if c.authMethod != "token" {
+		c.ownToken = secret.Auth.ClientToken
+	}
 
-	secret, err = c.client.Auth().Token().LookupSelf()
+	secret, err = lookupSelfWithContext(ctx, c.client)
 	if err != nil {
 		return fmt.Errorf("no token found in Vault client: %s", err)
 	}
@@ -194,18 +336,151 @@ func (c *BaseClient) Authenticate(role string) error {
 	return nil
 }
 
+// MountVersion - the KV API version of the mount path is on, 1 or 2. KV v2 secrets are written
+// as {"data": fields}, with any options alongside.
+func (c *BaseClient) MountVersion(ctx context.Context, path string) (int, error) {
+	return c.mounts.version(ctx, c.client, path)
+}
+
+// SecretPath - the API path the secret at path is read from, which for KV v2 has "data" after
+// the mount, e.g. secret/data/app. Policies grant access to this path rather than the secret's.
+func (c *BaseClient) SecretPath(ctx context.Context, path string) (string, error) {
+	return pathToSecret(ctx, c.client, c.mounts, path)
+}
+
+// MetadataPath - the API path of the metadata of the KV v2 secret at path, which has "metadata"
+// after the mount, e.g. secret/metadata/app. Reading with an as-of time reads this path too.
+func (c *BaseClient) MetadataPath(ctx context.Context, path string) (string, error) {
+	mount, version, err := c.mounts.mount(ctx, c.client, path)
+	if err != nil {
+		return "", err
+	}
+	if version != 2 {
+		return "", fmt.Errorf("%s is not on a KV v2 mount, so has no metadata", path)
+	}
+	return metadataPath(mount, path), nil
+}
+
 // Read - Read the given path
-func (c *BaseClient) Read(path string) (s *vaultApi.Secret, err error) {
-	p, err := pathToSecret(c.client, path)
+func (c *BaseClient) Read(ctx context.Context, path string) (s *vaultApi.Secret, err error) {
+	if err := c.refreshToken(); err != nil {
+		return nil, err
+	}
+	if !c.asOf.IsZero() {
+		return c.ReadVersion(ctx, path, 0)
+	}
+	p, err := pathToSecret(ctx, c.client, c.mounts, path)
 	if err != nil {
 		return nil, err
 	}
-	return c.client.Logical().Read(p)
+	return readWithContext(ctx, c.client, p, nil)
+}
+
+// ReadVersion - Read a version of the KV v2 secret at the given path, or the latest if version
+// is 0. If the client has an as-of time, the latest version is the latest at that time, and
+// a version created after it is an error.
+func (c *BaseClient) ReadVersion(ctx context.Context, path string, version int) (*vaultApi.Secret, error) {
+	if err := c.refreshToken(); err != nil {
+		return nil, err
+	}
+	mount, mountVersion, err := c.mounts.mount(ctx, c.client, path)
+	if err != nil {
+		return nil, err
+	}
+	if mountVersion != 2 {
+		return nil, fmt.Errorf("%s is not on a KV v2 mount, so has no versions to read", path)
+	}
+
+	if !c.asOf.IsZero() {
+		version, err = c.versionAsOf(ctx, mount, path, version)
+		if err != nil {
+			return nil, err
+		}
+		c.logger.Debugf("Reading version %d of %s, as of %s", version, path, c.asOf.Format(time.RFC3339))
+	}
+
+	if version == 0 {
+		return readWithContext(ctx, c.client, updatePath(mount, path), nil)
+	}
+	return readWithContext(ctx, c.client, updatePath(mount, path), url.Values{
+		"version": {strconv.Itoa(version)},
+	})
+}
+
+// versionAsOf - the version of a KV v2 secret which was current at the client's as-of time,
+// checking that the given version, if not 0, already existed at that time
+func (c *BaseClient) versionAsOf(ctx context.Context, mount, path string, version int) (int, error) {
+	metadata, err := readWithContext(ctx, c.client, metadataPath(mount, path), nil)
+	if err != nil {
+		return 0, err
+	}
+	if metadata == nil || metadata.Data == nil {
+		return 0, fmt.Errorf("no metadata found for %s", path)
+	}
+	versions, ok := metadata.Data["versions"].(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("could not parse versions in metadata for %s", path)
+	}
+
+	asOf := c.asOf.Format(time.RFC3339)
+	current := 0
+	var currentMeta map[string]interface{}
+	for v, raw := range versions {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("could not parse version %q in metadata for %s", v, path)
+		}
+		meta, ok := raw.(map[string]interface{})
+		if !ok {
+			return 0, fmt.Errorf("could not parse metadata of version %d of %s", n, path)
+		}
+		created, err := metadataTime(meta, "created_time")
+		if err != nil || created.IsZero() {
+			return 0, fmt.Errorf("could not parse created_time of version %d of %s", n, path)
+		}
+		if created.After(c.asOf) {
+			if n == version {
+				return 0, fmt.Errorf("version %d of %s was created after %s", n, path, asOf)
+			}
+			continue
+		}
+		if (version == 0 && n > current) || n == version {
+			current = n
+			currentMeta = meta
+		}
+	}
+
+	if current == 0 {
+		if version != 0 {
+			return 0, fmt.Errorf("version %d of %s does not exist", version, path)
+		}
+		return 0, fmt.Errorf("no version of %s existed at %s", path, asOf)
+	}
+	deleted, err := metadataTime(currentMeta, "deletion_time")
+	if err == nil && !deleted.IsZero() && !deleted.After(c.asOf) {
+		return 0, fmt.Errorf("version %d of %s had been deleted at %s", current, path, asOf)
+	}
+	if destroyed, _ := currentMeta["destroyed"].(bool); destroyed {
+		return 0, fmt.Errorf("version %d of %s has been destroyed", current, path)
+	}
+	return current, nil
+}
+
+// metadataTime - parse a time from KV v2 version metadata, which is empty if unset
+func metadataTime(meta map[string]interface{}, key string) (time.Time, error) {
+	s, _ := meta[key].(string)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
 }
 
 // List - list at given path
-func (c *BaseClient) List(path string) (*vaultApi.Secret, error) {
-	return c.client.Logical().List(path)
+func (c *BaseClient) List(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	if err := c.refreshToken(); err != nil {
+		return nil, err
+	}
+	return listWithContext(ctx, c.client, path)
 }
 
 // ListAuth - list configured auth methods
@@ -2,18 +2,24 @@ package vault
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 
 	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
+	logTest "github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/al4/talebearer/vault/vaulttest"
 )
 
 func TestNewVaultClient(t *testing.T) {
@@ -43,9 +49,21 @@ func (h *mockHandler) Help() string {
 type roundTripper struct {
 	ReturnResponseSecret *vaultApi.Secret
 	ReturnError          error
+	Requests             []string // The path and query of each request made
+	Bodies               []string // The body of each request made
 }
 
 func (rt *roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt.Requests = append(rt.Requests, r.URL.RequestURI())
+	body := ""
+	if r.Body != nil {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = string(b)
+	}
+	rt.Bodies = append(rt.Bodies, body)
 	js, err := json.Marshal(rt.ReturnResponseSecret)
 	if err != nil {
 		return nil, err
@@ -60,12 +78,18 @@ func (rt *roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
 // generateVaultClient - generate a mock vault client for use in tests
 // All http requests issued with this client will return the given vault Secret
 func generateVaultClient(returnSecret *vaultApi.Secret, returnError error) (*vaultApi.Client, error) {
+	return generateVaultClientWithTransport(&roundTripper{
+		ReturnResponseSecret: returnSecret,
+		ReturnError:          returnError,
+	})
+}
+
+// generateVaultClientWithTransport - generate a vault client which sends requests to rt, so the
+// requests made can be inspected
+func generateVaultClientWithTransport(rt *roundTripper) (*vaultApi.Client, error) {
 	vaultConfig := vaultApi.DefaultConfig()
 	vaultConfig.HttpClient = &http.Client{
-		Transport: &roundTripper{
-			ReturnResponseSecret: returnSecret,
-			ReturnError:          returnError,
-		},
+		Transport: rt,
 	}
 	return vaultApi.NewClient(vaultConfig)
 }
@@ -92,7 +116,7 @@ func TestBaseClient_Authenticate_CallsHandler(t *testing.T) {
 		logger:      log.WithField("test", true),
 	}
 
-	err = client.Authenticate("testRole")
+	err = client.Authenticate(context.Background(), "testRole")
 	if err != nil {
 		t.Error(err)
 	}
@@ -118,7 +142,7 @@ func TestBaseClient_AuthenticateAuthError(t *testing.T) {
 		authHandler: handler,
 		logger:      log.WithField("test", true),
 	}
-	err = client.Authenticate("testRole")
+	err = client.Authenticate(context.Background(), "testRole")
 	if err == nil {
 		t.Error("error should not be nil")
 	}
@@ -147,7 +171,7 @@ func TestBaseClient_Read(t *testing.T) {
 		logger:      log.WithField("test", true),
 	}
 
-	s, err := client.Read("/secret/test")
+	s, err := client.Read(context.Background(), "/secret/test")
 	if err != nil {
 		t.Error(err)
 	}
@@ -181,7 +205,7 @@ func TestBaseClient_Read_KvAPIV2(t *testing.T) {
 		logger:      log.WithField("test", true),
 	}
 
-	s, err := client.Read("/secret/test")
+	s, err := client.Read(context.Background(), "/secret/test")
 	if err != nil {
 		t.Error(err)
 	}
@@ -190,6 +214,133 @@ func TestBaseClient_Read_KvAPIV2(t *testing.T) {
 	}
 }
 
+// kvV2Response - a response which serves as a KV v2 mount, the metadata of a secret with the given
+// versions, and its data
+func kvV2Response(versions map[string]interface{}) *vaultApi.Secret {
+	return &vaultApi.Secret{
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"foo": "bar",
+			},
+			"options": map[string]interface{}{
+				"version": "2",
+			},
+			"versions": versions,
+		},
+	}
+}
+
+func TestBaseClient_ReadVersion(t *testing.T) {
+	rt := &roundTripper{ReturnResponseSecret: kvV2Response(nil)}
+	vaultClient, err := generateVaultClientWithTransport(rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &BaseClient{
+		client: vaultClient,
+		logger: log.WithField("test", true),
+	}
+
+	_, err = client.ReadVersion(context.Background(), "/secret/test", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "/v1/secret/data/test?version=7"
+	if last := rt.Requests[len(rt.Requests)-1]; last != expected {
+		t.Errorf("expected request to %s, got %s", expected, last)
+	}
+}
+
+func TestBaseClient_ReadVersion_KvAPIV1(t *testing.T) {
+	vaultClient, err := generateVaultClient(&vaultApi.Secret{
+		Data: map[string]interface{}{"foo": "bar"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &BaseClient{
+		client: vaultClient,
+		logger: log.WithField("test", true),
+	}
+
+	_, err = client.ReadVersion(context.Background(), "secret/test", 7)
+	if err == nil || !strings.Contains(err.Error(), "not on a KV v2 mount") {
+		t.Errorf("expected an error about the KV version, got %v", err)
+	}
+}
+
+func TestBaseClient_Read_AsOf(t *testing.T) {
+	versions := map[string]interface{}{
+		"1": map[string]interface{}{"created_time": "2019-01-01T00:00:00.000000Z", "deletion_time": ""},
+		"2": map[string]interface{}{"created_time": "2019-02-01T00:00:00.000000Z", "deletion_time": ""},
+		"3": map[string]interface{}{"created_time": "2019-03-01T00:00:00.000000Z", "deletion_time": ""},
+		"4": map[string]interface{}{
+			"created_time":  "2019-04-01T00:00:00.000000Z",
+			"deletion_time": "2019-04-02T00:00:00.000000Z",
+		},
+		"5": map[string]interface{}{
+			"created_time":  "2019-05-01T00:00:00.000000Z",
+			"deletion_time": "",
+			"destroyed":     true,
+		},
+	}
+	var tests = []struct {
+		asOf     string
+		version  int
+		expected string // Request expected for the data, empty if an error is expected
+		err      string
+	}{
+		{"2019-02-15T00:00:00Z", 0, "/v1/secret/data/test?version=2", ""},
+		{"2019-02-01T00:00:00Z", 0, "/v1/secret/data/test?version=2", ""},
+		{"2019-04-01T12:00:00Z", 0, "/v1/secret/data/test?version=4", ""},
+		{"2019-03-15T00:00:00Z", 1, "/v1/secret/data/test?version=1", ""},
+		{"2019-03-15T00:00:00Z", 4, "", "version 4 of secret/test was created after 2019-03-15T00:00:00Z"},
+		{"2019-04-03T00:00:00Z", 0, "", "version 4 of secret/test had been deleted"},
+		{"2019-06-01T00:00:00Z", 0, "", "version 5 of secret/test has been destroyed"},
+		{"2018-12-01T00:00:00Z", 0, "", "no version of secret/test existed at 2018-12-01T00:00:00Z"},
+		{"2019-06-01T00:00:00Z", 9, "", "version 9 of secret/test does not exist"},
+	}
+
+	for _, tc := range tests {
+		asOf, err := time.Parse(time.RFC3339, tc.asOf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rt := &roundTripper{ReturnResponseSecret: kvV2Response(versions)}
+		vaultClient, err := generateVaultClientWithTransport(rt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &BaseClient{
+			client: vaultClient,
+			logger: log.WithField("test", true),
+		}
+		WithAsOf(asOf)(client)
+
+		if tc.version == 0 {
+			_, err = client.Read(context.Background(), "secret/test")
+		} else {
+			_, err = client.ReadVersion(context.Background(), "secret/test", tc.version)
+		}
+
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("as of %s, version %d: expected error containing %q, got %v",
+					tc.asOf, tc.version, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("as of %s, version %d: %s", tc.asOf, tc.version, err)
+			continue
+		}
+		if last := rt.Requests[len(rt.Requests)-1]; last != tc.expected {
+			t.Errorf("as of %s, version %d: expected request to %s, got %s",
+				tc.asOf, tc.version, tc.expected, last)
+		}
+	}
+}
+
 func TestBaseClient_Read_KvAPIUnknown(t *testing.T) {
 	// Test an error is raised when the api version is unsupported
 	testData := map[string]interface{}{
@@ -214,7 +365,7 @@ func TestBaseClient_Read_KvAPIUnknown(t *testing.T) {
 		logger:      log.WithField("test", true),
 	}
 
-	s, err := client.Read("/secret/test")
+	s, err := client.Read(context.Background(), "/secret/test")
 	if err == nil {
 		t.Error("expected error")
 	}
@@ -251,7 +402,7 @@ func TestBaseClient_Write(t *testing.T) {
 		logger:      logger,
 	}
 
-	s, err := client.Write("/secret/test", testData)
+	s, err := client.Write(context.Background(), "/secret/test", testData)
 	if err != nil {
 		t.Error(err)
 	}
@@ -269,11 +420,473 @@ func Test_sanitisePath(t *testing.T) {
 	}
 }
 
-func Test_updatePath(t *testing.T) {
-	path := "secret/foo"
-	expected := "secret/data/foo"
-	result := updatePath(path)
+func Test_metadataPath(t *testing.T) {
+	path := "/secret/foo/bar"
+	expected := "secret/metadata/foo/bar"
+	result := metadataPath("secret/", path)
 	if expected != result {
 		t.Errorf("Result '%s', expected '%s'", result, expected)
 	}
 }
+
+func Test_updatePath(t *testing.T) {
+	for _, tc := range []struct{ mount, path, expected string }{
+		{"secret", "secret/foo", "secret/data/foo"},
+		{"team/kv/", "team/kv/app/db", "team/kv/data/app/db"},
+		{"data", "data/data/x", "data/data/data/x"},
+		{"secret", "secret", "secret/data"},
+	} {
+		if result := updatePath(tc.mount, tc.path); tc.expected != result {
+			t.Errorf("Result '%s', expected '%s'", result, tc.expected)
+		}
+	}
+}
+
+func TestBaseClient_ListDocuments(t *testing.T) {
+	rt := &roundTripper{ReturnResponseSecret: &vaultApi.Secret{
+		Data: map[string]interface{}{
+			"options": map[string]interface{}{"version": "2"},
+			"keys":    []interface{}{"db", "app/"},
+		},
+	}}
+	vaultClient, err := generateVaultClientWithTransport(rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &BaseClient{
+		client: vaultClient,
+		logger: log.WithField("test", true),
+	}
+
+	names, err := client.ListDocuments(context.Background(), "secret/team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string{"db", "app/"}, names) {
+		t.Errorf("unexpected names %v", names)
+	}
+	expected := "/v1/secret/metadata/team?list=true"
+	if last := rt.Requests[len(rt.Requests)-1]; last != expected {
+		t.Errorf("expected request to %s, got %s", expected, last)
+	}
+}
+
+// mountResponse - a response which serves as the KV v2 mount secret/, and a secret on it
+func mountResponse() *vaultApi.Secret {
+	return &vaultApi.Secret{
+		Data: map[string]interface{}{
+			"path": "secret/",
+			"data": map[string]interface{}{
+				"foo": "bar",
+			},
+			"options": map[string]interface{}{
+				"version": "2",
+			},
+		},
+	}
+}
+
+// mountRequests - the number of requests made to the mounts endpoint
+func mountRequests(rt *roundTripper) int {
+	n := 0
+	for _, r := range rt.Requests {
+		if strings.HasPrefix(r, "/v1/sys/internal/ui/mounts/") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestBaseClient_Read_CachesMountVersion(t *testing.T) {
+	rt := &roundTripper{ReturnResponseSecret: mountResponse()}
+	vaultClient, err := generateVaultClientWithTransport(rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &BaseClient{
+		client: vaultClient,
+		logger: log.WithField("test", true),
+		mounts: newMountTable(),
+	}
+
+	for _, path := range []string{"secret/a", "/secret/b/c", "secret/a"} {
+		if _, err := client.Read(context.Background(), path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := mountRequests(rt); n != 1 {
+		t.Errorf("expected 1 request to the mounts endpoint, got %d", n)
+	}
+	expected := "/v1/secret/data/b/c"
+	if r := rt.Requests[2]; r != expected {
+		t.Errorf("expected request to %s, got %s", expected, r)
+	}
+}
+
+func TestBaseClient_Read_DeclaredMountVersions(t *testing.T) {
+	rt := &roundTripper{ReturnResponseSecret: mountResponse()}
+	vaultClient, err := generateVaultClientWithTransport(rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &BaseClient{
+		client: vaultClient,
+		logger: log.WithField("test", true),
+		mounts: newMountTable(),
+	}
+	WithMountVersions(map[string]int{"secret": 2, "secret/legacy": 1})(client)
+
+	if _, err := client.Read(context.Background(), "secret/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Read(context.Background(), "secret/legacy/b"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/v1/secret/data/a", "/v1/secret/legacy/b"}
+	if !reflect.DeepEqual(expected, rt.Requests) {
+		t.Errorf("expected requests %v, got %v", expected, rt.Requests)
+	}
+}
+
+func TestParseMountVersions(t *testing.T) {
+	versions, err := ParseMountVersions("secret=2, /legacy/kv/ = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"secret": 2, "legacy/kv": 1}
+	if !reflect.DeepEqual(expected, versions) {
+		t.Errorf("expected %v, got %v", expected, versions)
+	}
+
+	for _, s := range []string{"secret", "=2", "secret=3", "secret=two"} {
+		if _, err := ParseMountVersions(s); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestBaseClient_Read_Retries(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": {"foo": "bar"}}`))
+	}))
+	defer srv.Close()
+
+	config := vaultApi.DefaultConfig()
+	config.Address = srv.URL
+	vaultClient, err := vaultApi.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &BaseClient{
+		client: vaultClient,
+		logger: log.WithField("test", true),
+		mounts: newMountTable(),
+	}
+	WithMountVersions(map[string]int{"secret": 1})(client)
+	WithRetryPolicy(RetryPolicy{MaxRetries: 2, MinWait: time.Millisecond, MaxWait: time.Millisecond})(client)
+
+	s, err := client.Read(context.Background(), "secret/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Data["foo"] != "bar" {
+		t.Errorf("unexpected data %v", s.Data)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestBaseClient_Read_Cancelled(t *testing.T) {
+	rt := &roundTripper{ReturnResponseSecret: mountResponse()}
+	vaultClient, err := generateVaultClientWithTransport(rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &BaseClient{
+		client:      vaultClient,
+		authHandler: &mockHandler{},
+		logger:      log.WithField("test", true),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Read(ctx, "secret/test"); err == nil {
+		t.Error("expected an error reading with a cancelled context")
+	}
+	if err := client.Authenticate(ctx, "testRole"); err != context.Canceled {
+		t.Errorf("expected %s, got %v", context.Canceled, err)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{MinWait: 100 * time.Millisecond, MaxWait: time.Second}
+	for attempt, max := range []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+		800 * time.Millisecond, time.Second, time.Second,
+	} {
+		for i := 0; i < 20; i++ {
+			wait := p.backoff(0, 0, attempt, nil)
+			if wait < max/2 || wait > max {
+				t.Errorf("attempt %d: wait %s not between %s and %s", attempt, wait, max/2, max)
+			}
+		}
+	}
+	if wait := p.backoff(0, 0, 100, nil); wait > time.Second {
+		t.Errorf("wait %s longer than the maximum", wait)
+	}
+}
+
+// newFakeVault - a fake Vault server seeded from testdata/vault.yaml, and a client of it which
+// is authenticated with the root token unless there are opts
+func newFakeVault(t *testing.T, readonly bool, opts ...Option) (*vaulttest.Server, *BaseClient) {
+	srv := vaulttest.NewTestServer(t, "testdata/vault.yaml")
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", vaulttest.RootToken)
+	if len(opts) > 0 {
+		t.Setenv("VAULT_TOKEN", "")
+		t.Setenv("HOME", t.TempDir()) // So that no ~/.vault-token is used
+	}
+	c, err := NewVaultClient(readonly, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, c.(*BaseClient)
+}
+
+func TestBaseClient_FakeVault_ReadDocument(t *testing.T) {
+	srv, client := newFakeVault(t, true)
+	ctx := context.Background()
+	if err := client.Authenticate(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		path     string
+		version  int
+		expected map[string]interface{}
+	}{
+		{"secret/app", 0, map[string]interface{}{"password": "second", "user": "app"}},
+		{"secret/app", 1, map[string]interface{}{"password": "first"}},
+		{"secret/app/db", 0, map[string]interface{}{"host": "db.example.com"}},
+		{"legacy/app", 0, map[string]interface{}{"password": "legacy"}},
+		{"kv/config", 0, map[string]interface{}{"region": "eu-west-1"}},
+		{"team/kv/app", 0, map[string]interface{}{"token": "nested"}},
+		{"team/kv/app", 1, map[string]interface{}{"token": "nested"}},
+	}
+	for _, tc := range testCases {
+		fields, err := client.ReadDocument(ctx, tc.path, tc.version)
+		if err != nil {
+			t.Errorf("%s@%d: unexpected error: %s", tc.path, tc.version, err)
+			continue
+		}
+		if !reflect.DeepEqual(tc.expected, fields) {
+			t.Errorf("%s@%d: expected %v, got %v", tc.path, tc.version, tc.expected, fields)
+		}
+	}
+
+	if _, err := client.ReadDocument(ctx, "secret/missing", 0); err == nil {
+		t.Error("expected an error reading a missing secret")
+	}
+	if _, err := client.ReadDocument(ctx, "legacy/app", 1); err == nil {
+		t.Error("expected an error reading a version from a KV v1 mount")
+	}
+
+	// Each mount's version is looked up once, however many secrets are read from it
+	lookups := 0
+	for _, r := range srv.Requests() {
+		if strings.HasPrefix(r, "GET sys/internal/ui/mounts/") {
+			lookups++
+		}
+	}
+	if lookups != 4 {
+		t.Errorf("expected 4 mount lookups, got %d in %v", lookups, srv.Requests())
+	}
+}
+
+func TestBaseClient_FakeVault_ListDocuments(t *testing.T) {
+	_, client := newFakeVault(t, true)
+	ctx := context.Background()
+
+	for path, expected := range map[string][]string{
+		"secret":  {"app", "app/"},
+		"legacy/": {"app"},
+		"kv":      {"config"},
+	} {
+		names, err := client.ListDocuments(ctx, path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(expected, names) {
+			t.Errorf("%s: expected %v, got %v", path, expected, names)
+		}
+	}
+}
+
+func TestBaseClient_FakeVault_Write(t *testing.T) {
+	_, client := newFakeVault(t, false)
+	ctx := context.Background()
+
+	if _, err := client.Write(ctx, "secret/app", map[string]interface{}{
+		"data":    map[string]interface{}{"password": "third"},
+		"options": map[string]interface{}{"cas": 2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write(ctx, "legacy/new", map[string]interface{}{"key": "value"}); err != nil {
+		t.Fatal(err)
+	}
+	// The check-and-set version is no longer current
+	if _, err := client.Write(ctx, "secret/app", map[string]interface{}{
+		"data":    map[string]interface{}{"password": "fourth"},
+		"options": map[string]interface{}{"cas": 2},
+	}); err == nil {
+		t.Error("expected a check-and-set error")
+	}
+
+	for path, expected := range map[string]map[string]interface{}{
+		"secret/app": {"password": "third"},
+		"legacy/new": {"key": "value"},
+	} {
+		fields, err := client.ReadDocument(ctx, path, 0)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(expected, fields) {
+			t.Errorf("%s: expected %v, got %v", path, expected, fields)
+		}
+	}
+}
+
+func TestBaseClient_Write_LogsFieldNames(t *testing.T) {
+	hook := logTest.NewGlobal()
+	defer hook.Reset()
+	defer log.SetLevel(log.GetLevel())
+	log.SetLevel(log.DebugLevel)
+
+	for _, readonly := range []bool{true, false} {
+		hook.Reset()
+		_, client := newFakeVault(t, readonly)
+		if _, err := client.Write(context.Background(), "secret/app", map[string]interface{}{
+			"data": map[string]interface{}{"password": "hunter2", "user": "app"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		entry := hook.LastEntry()
+		if entry == nil {
+			t.Fatalf("readonly %v: expected the write to be logged", readonly)
+		}
+		if fields := entry.Data["fields"]; !reflect.DeepEqual([]string{"password", "user"}, fields) {
+			t.Errorf("readonly %v: expected the field names to be logged, got %v", readonly, fields)
+		}
+		if line, _ := entry.String(); strings.Contains(line, "hunter2") {
+			t.Errorf("readonly %v: expected no values to be logged, got %s", readonly, line)
+		}
+	}
+}
+
+func TestBaseClient_FakeVault_AppRole(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newFakeVault(t, true, WithAuthMethod("approle", map[string]string{
+		"role_id": "app-role-id", "secret_id": "app-secret-id",
+	}))
+	if err := client.Authenticate(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadDocument(ctx, "secret/app", 0); err != nil {
+		t.Error(err)
+	}
+	if err := client.RevokeTokens(ctx); err != nil {
+		t.Fatal(err)
+	}
+	requests := srv.Requests()
+	if last := requests[len(requests)-1]; last != "PUT auth/token/revoke-self" {
+		t.Errorf("expected the token to be revoked, got %s", last)
+	}
+
+	_, client = newFakeVault(t, true, WithAuthMethod("approle", map[string]string{
+		"role_id": "app-role-id", "secret_id": "wrong",
+	}))
+	err := client.Authenticate(ctx, "")
+	if err == nil || !strings.Contains(err.Error(), "invalid role or secret ID") {
+		t.Errorf("expected a login error, got %v", err)
+	}
+}
+
+func TestBaseClient_FakeVault_SecretPath(t *testing.T) {
+	_, client := newFakeVault(t, true)
+	ctx := context.Background()
+
+	for path, expected := range map[string]string{
+		"secret/app":    "secret/data/app",
+		"secret/app/db": "secret/data/app/db",
+		"legacy/app":    "legacy/app",
+		"kv/config":     "kv/data/config",
+		"team/kv/app":   "team/kv/data/app",
+	} {
+		p, err := client.SecretPath(ctx, path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", path, err)
+			continue
+		}
+		if p != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, p)
+		}
+	}
+	if _, err := client.SecretPath(ctx, "missing/app"); err == nil {
+		t.Error("expected an error for a path which isn't on a mount")
+	}
+}
+
+func TestBaseClient_FakeVault_MetadataPath(t *testing.T) {
+	_, client := newFakeVault(t, true)
+	ctx := context.Background()
+
+	for path, expected := range map[string]string{
+		"secret/app":  "secret/metadata/app",
+		"team/kv/app": "team/kv/metadata/app",
+	} {
+		p, err := client.MetadataPath(ctx, path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", path, err)
+			continue
+		}
+		if p != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, p)
+		}
+	}
+	if _, err := client.MetadataPath(ctx, "legacy/app"); err == nil {
+		t.Error("expected an error for a secret on a KV v1 mount")
+	}
+}
+
+func TestBaseClient_FakeVault_Policies(t *testing.T) {
+	_, client := newFakeVault(t, false)
+
+	rules, err := client.GetPolicy("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rules, `path "secret/data/app"`) {
+		t.Errorf("unexpected rules of policy app: %q", rules)
+	}
+	if rules, err := client.GetPolicy("missing"); err != nil || rules != "" {
+		t.Errorf("expected no rules for a missing policy, got %q, %v", rules, err)
+	}
+
+	updated := `path "legacy/app" { capabilities = ["read"] }`
+	if err := client.PutPolicy("app", updated); err != nil {
+		t.Fatal(err)
+	}
+	if rules, err := client.GetPolicy("app"); err != nil || rules != updated {
+		t.Errorf("expected %q, got %q, %v", updated, rules, err)
+	}
+}
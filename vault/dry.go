@@ -1,6 +1,8 @@
 package vault
 
 import (
+	"context"
+
 	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 )
@@ -44,16 +46,16 @@ func (c *dryClient) DeletePolicy(name string) error {
 	return nil
 }
 
-func (c *dryClient) Write(path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+func (c *dryClient) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
 	c.logger.WithFields(log.Fields{
 		"action": "Write",
 		"path":   path,
-		"data":   data,
+		"fields": fieldNames(data),
 	}).Debug("No Vault API call made")
 	return &vaultApi.Secret{}, nil
 }
 
-func (c *dryClient) Delete(path string) (*vaultApi.Secret, error) {
+func (c *dryClient) Delete(ctx context.Context, path string) (*vaultApi.Secret, error) {
 	c.logger.WithFields(log.Fields{
 		"action": "Delete",
 		"path":   path,
@@ -0,0 +1,137 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Tokens which talebearer logs in for are its own, so are revoked by RevokeTokens once a render
+// is done, rather than being left alive until they expire. Tokens issued elsewhere are left
+// alone, although a child token created from one with WithChildToken is revoked.
+
+// WithChildToken - once authenticated, create a child token which expires after ttl and may be
+// used at most uses times, and use it instead. A ttl of 0 is the parent's default TTL, and uses of
+// 0 is no limit. Each secret read, KV mount lookup and the final revocation is a use.
+func WithChildToken(ttl time.Duration, uses int) Option {
+	return func(c *BaseClient) {
+		c.childTTL = ttl
+		c.childUses = uses
+	}
+}
+
+// WithTokenRenewal - keep renewing the token before it expires, until the context given to
+// Authenticate is done or the token is revoked, for renders which may outlive its TTL
+func WithTokenRenewal() Option {
+	return func(c *BaseClient) {
+		c.renew = true
+	}
+}
+
+// createChildToken - create a child token as configured by WithChildToken, and use it
+func (c *BaseClient) createChildToken(ctx context.Context) error {
+	data := map[string]interface{}{
+		"display_name": "talebearer",
+		"num_uses":     c.childUses,
+	}
+	if c.childTTL > 0 {
+		data["ttl"] = c.childTTL.String()
+	}
+	secret, err := writeWithContext(ctx, c.client, "auth/token/create", data)
+	if err != nil {
+		return fmt.Errorf("failed creating child token: %s", err)
+	}
+	if secret == nil || secret.Auth == nil {
+		return fmt.Errorf("failed creating child token, no token was returned")
+	}
+
+	c.client.SetToken(secret.Auth.ClientToken)
+	if c.ownToken == "" {
+		c.ownToken = secret.Auth.ClientToken
+	}
+	// The child, rather than the agent's token, is used from now on
+	c.sink = nil
+	c.logger.Debugf("Created a child token with a TTL of %ds and %d uses",
+		secret.Auth.LeaseDuration, c.childUses)
+	return nil
+}
+
+// startRenewal - renew the token in the background at half its remaining TTL, if it can be
+// renewed, until ctx is done or RevokeTokens is called
+func (c *BaseClient) startRenewal(ctx context.Context) error {
+	if c.sink != nil {
+		c.logger.Debugf("Not renewing the token, which Vault Agent renews")
+		return nil
+	}
+	secret, err := lookupSelfWithContext(ctx, c.client)
+	if err != nil {
+		return fmt.Errorf("failed looking up token to renew: %s", err)
+	}
+	renewable, err := secret.TokenIsRenewable()
+	if err != nil {
+		return fmt.Errorf("failed looking up token to renew: %s", err)
+	}
+	ttl, err := secret.TokenTTL()
+	if err != nil {
+		return fmt.Errorf("failed looking up token to renew: %s", err)
+	}
+	if !renewable || ttl <= 0 {
+		c.logger.Debugf("Not renewing the token, which is not renewable or doesn't expire")
+		return nil
+	}
+
+	ctx, c.stopRenewal = context.WithCancel(ctx)
+	go c.renewToken(ctx, ttl)
+	return nil
+}
+
+// renewToken - renew the token until ctx is done, it can no longer be renewed, or its TTL is
+// too short to renew it again
+func (c *BaseClient) renewToken(ctx context.Context, ttl time.Duration) {
+	for ttl >= time.Second {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(ttl / 2):
+		}
+
+		secret, err := writeWithContext(ctx, c.client, "auth/token/renew-self", nil)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			c.logger.Warnf("Failed renewing token, retrying: %s", err)
+			ttl /= 2
+			continue
+		case secret == nil || secret.Auth == nil:
+			c.logger.Warnf("Failed renewing token, no token was returned")
+			return
+		}
+		ttl = time.Duration(secret.Auth.LeaseDuration) * time.Second
+		c.logger.Debugf("Renewed token, which now expires in %s", ttl)
+		if !secret.Auth.Renewable {
+			return
+		}
+	}
+}
+
+// RevokeTokens - stop renewing the token, and revoke the first token talebearer created, which
+// also revokes any child of it. Tokens issued elsewhere are not revoked.
+func (c *BaseClient) RevokeTokens(ctx context.Context) error {
+	if c.stopRenewal != nil {
+		c.stopRenewal()
+	}
+	if c.ownToken == "" {
+		return nil
+	}
+
+	r := c.client.NewRequest("PUT", "/v1/auth/token/revoke-self")
+	r.ClientToken = c.ownToken
+	if _, err := doRequest(ctx, c.client, r); err != nil {
+		return fmt.Errorf("failed revoking token: %s", err)
+	}
+	c.logger.Debugf("Revoked token")
+	c.ownToken = ""
+	c.client.ClearToken()
+	return nil
+}
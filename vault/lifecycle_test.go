@@ -0,0 +1,137 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	vaultApi "github.com/hashicorp/vault/api"
+
+	"github.com/al4/talebearer/vault/vaulttest"
+)
+
+// withAppRole - log in with the approle in testdata/vault.yaml
+var withAppRole = WithAuthMethod("approle", map[string]string{
+	"role_id": "app-role-id", "secret_id": "app-secret-id",
+})
+
+// tokenValid - whether srv accepts token
+func tokenValid(t *testing.T, srv *vaulttest.Server, token string) bool {
+	client, err := vaultApi.NewClient(&vaultApi.Config{Address: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(token)
+	_, err = client.Auth().Token().LookupSelf()
+	return err == nil
+}
+
+// countRequests - the number of requests made to srv with the method and path in request
+func countRequests(srv *vaulttest.Server, request string) int {
+	n := 0
+	for _, r := range srv.Requests() {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+func TestBaseClient_RevokeTokens_LoggedIn(t *testing.T) {
+	srv, client := newFakeVault(t, true, WithChildToken(5*time.Minute, 3), withAppRole)
+
+	if err := client.Authenticate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	parent, child := client.ownToken, client.client.Token()
+	if parent == "" || child == parent {
+		t.Fatalf("expected a child of the token logged in with to be used, got %q of %q", child, parent)
+	}
+	secret, err := client.client.Auth().Token().LookupSelf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["ttl"] != json.Number("300") || secret.Data["num_uses"] != json.Number("3") {
+		t.Errorf("expected the child token to have a TTL of 300 and 3 uses, got %v", secret.Data)
+	}
+
+	if err := client.RevokeTokens(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Revoking the token talebearer logged in with also revokes its child
+	if n := countRequests(srv, "PUT auth/token/revoke-self"); n != 1 {
+		t.Errorf("expected only the parent token to be revoked, got %d revocations", n)
+	}
+	for _, token := range []string{parent, child} {
+		if tokenValid(t, srv, token) {
+			t.Errorf("expected token %s to be revoked", token)
+		}
+	}
+	if token := client.client.Token(); token != "" {
+		t.Errorf("expected the token to be cleared, got %s", token)
+	}
+}
+
+func TestBaseClient_RevokeTokens_IssuedElsewhere(t *testing.T) {
+	srv, client := newFakeVault(t, true, withAppRole)
+	client.client.SetToken("from-env")
+	if err := client.Authenticate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RevokeTokens(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRequests(srv, "PUT auth/token/revoke-self"); n > 0 {
+		t.Errorf("expected no tokens to be revoked, got %d revocations", n)
+	}
+
+	srv, client = newFakeVault(t, true, WithChildToken(0, 10), withAppRole)
+	client.client.SetToken("from-env")
+	if err := client.Authenticate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	child := client.client.Token()
+	if err := client.RevokeTokens(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if tokenValid(t, srv, child) {
+		t.Errorf("expected the child token to be revoked")
+	}
+	if !tokenValid(t, srv, "from-env") {
+		t.Errorf("expected the token issued elsewhere not to be revoked")
+	}
+}
+
+func TestBaseClient_TokenRenewal(t *testing.T) {
+	srv, client := newFakeVault(t, true, WithTokenRenewal(), withAppRole)
+	// A token issued elsewhere, which expires after 1s
+	root, err := vaultApi.NewClient(&vaultApi.Config{Address: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.SetToken(vaulttest.RootToken)
+	secret, err := root.Auth().Token().Create(&vaultApi.TokenCreateRequest{TTL: "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.client.SetToken(secret.Auth.ClientToken)
+
+	if err := client.Authenticate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	// The token has a TTL of 1s, so is renewed every 500ms
+	time.Sleep(1200 * time.Millisecond)
+	if err := client.RevokeTokens(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	renewals := countRequests(srv, "PUT auth/token/renew-self")
+	if renewals < 1 {
+		t.Errorf("expected the token to be renewed, got %d renewals", renewals)
+	}
+
+	time.Sleep(600 * time.Millisecond)
+	if after := countRequests(srv, "PUT auth/token/renew-self"); after != renewals {
+		t.Errorf("expected renewal to stop once tokens are revoked, got %d more", after-renewals)
+	}
+}
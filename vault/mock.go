@@ -1,10 +1,13 @@
 package vault
 
 import (
+	"context"
 	"fmt"
 
 	vaultApi "github.com/hashicorp/vault/api"
 	"github.com/stretchr/testify/mock"
+
+	"github.com/al4/talebearer/backend"
 )
 
 // MockClient - mock of a vault client
@@ -16,7 +19,7 @@ type MockClient struct {
 }
 
 // Authenticate - mock method
-func (m *MockClient) Authenticate(role string) error {
+func (m *MockClient) Authenticate(ctx context.Context, role string) error {
 	m.Called(role)
 	if role == "ConnectionRefused" {
 		return fmt.Errorf("dial tcp [::1]:8200: getsockopt: connection refused")
@@ -26,6 +29,30 @@ func (m *MockClient) Authenticate(role string) error {
 	return m.ReturnError
 }
 
+// MountVersion - mock method, every mount is KV v1
+func (m *MockClient) MountVersion(ctx context.Context, path string) (int, error) {
+	m.Called(path)
+	return 1, m.ReturnError
+}
+
+// SecretPath - mock method, every mount is KV v1 so the path is unchanged
+func (m *MockClient) SecretPath(ctx context.Context, path string) (string, error) {
+	m.Called(path)
+	return path, m.ReturnError
+}
+
+// MetadataPath - mock method, every mount is KV v1 so no secret has metadata
+func (m *MockClient) MetadataPath(ctx context.Context, path string) (string, error) {
+	m.Called(path)
+	return "", fmt.Errorf("%s is not on a KV v2 mount, so has no metadata", path)
+}
+
+// RevokeTokens - mock method
+func (m *MockClient) RevokeTokens(ctx context.Context) error {
+	m.Called()
+	return m.ReturnError
+}
+
 // DisableAuth - mock method
 func (m *MockClient) DisableAuth(path string) error {
 	m.Called(path)
@@ -71,25 +98,46 @@ func (m *MockClient) DeletePolicy(name string) error {
 }
 
 // Read - mock method
-func (m *MockClient) Read(path string) (*vaultApi.Secret, error) {
+func (m *MockClient) Read(ctx context.Context, path string) (*vaultApi.Secret, error) {
 	m.Called(path)
 	return m.ReturnSecret, m.ReturnError
 }
 
+// ReadVersion - mock method
+func (m *MockClient) ReadVersion(ctx context.Context, path string, version int) (*vaultApi.Secret, error) {
+	m.Called(path, version)
+	return m.ReturnSecret, m.ReturnError
+}
+
 // Write - mock method
-func (m *MockClient) Write(path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+func (m *MockClient) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
 	m.Called(path, data)
 	return m.ReturnSecret, m.ReturnError
 }
 
 // List - mock method
-func (m *MockClient) List(path string) (*vaultApi.Secret, error) {
+func (m *MockClient) List(ctx context.Context, path string) (*vaultApi.Secret, error) {
 	m.Called(path)
 	return m.ReturnSecret, m.ReturnError
 }
 
 // Delete - mock method
-func (m *MockClient) Delete(path string) (*vaultApi.Secret, error) {
+func (m *MockClient) Delete(ctx context.Context, path string) (*vaultApi.Secret, error) {
 	m.Called(path)
 	return m.ReturnSecret, m.ReturnError
 }
+
+// ReadDocument - reads the document with the mocked Read and ReadVersion methods
+func (m *MockClient) ReadDocument(ctx context.Context, path string, version int) (map[string]interface{}, error) {
+	return readDocument(ctx, m, path, version)
+}
+
+// ListDocuments - lists documents with the mocked List method
+func (m *MockClient) ListDocuments(ctx context.Context, path string) ([]string, error) {
+	return listDocuments(ctx, m, path)
+}
+
+// Capabilities - mock method
+func (m *MockClient) Capabilities() backend.Capabilities {
+	return backend.Capabilities{Versions: true, List: true}
+}
@@ -0,0 +1,180 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// mountTable - the KV API version of each mount, so that the version is looked up at most once
+// per mount, however many secrets are read from it. A nil mountTable caches nothing.
+type mountTable struct {
+	mu       sync.Mutex              // Only held while using the maps, never during a request
+	versions map[string]int          // Keyed by mount path, with a trailing slash, e.g. "secret/"
+	lookups  map[string]*mountLookup // Lookups in progress, keyed by the top-level mount guessed
+}
+
+// mountLookup - a lookup of a mount's version in progress, which concurrent reads from the same
+// mount wait for rather than making their own request
+type mountLookup struct {
+	done chan struct{}
+	err  error // Set before done is closed
+}
+
+func newMountTable() *mountTable {
+	return &mountTable{versions: make(map[string]int), lookups: make(map[string]*mountLookup)}
+}
+
+// declare - set the KV API version of a mount, so that it is never looked up
+func (t *mountTable) declare(mount string, version int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.versions[mountKey(mount)] = version
+}
+
+// version - the KV API version of the mount path is on, looking it up with client if it isn't
+// already known
+func (t *mountTable) version(ctx context.Context, client *vaultApi.Client, path string) (int, error) {
+	_, version, err := t.mount(ctx, client, path)
+	return version, err
+}
+
+// mount - the mount path is on, without slashes, and its KV API version, looking them up with
+// client if they aren't already known
+// Reads from the same top-level path share one lookup, while reads from other mounts go ahead
+// without waiting for it.
+func (t *mountTable) mount(ctx context.Context, client *vaultApi.Client, path string) (string, int, error) {
+	key := topLevelKey(path)
+	if t == nil {
+		mount, version, err := getMountVersion(ctx, client, path)
+		if mount == "" {
+			mount = key
+		}
+		return sanitisePath(mount), version, err
+	}
+
+	for {
+		t.mu.Lock()
+		if mount, version, ok := t.lookup(path); ok {
+			t.mu.Unlock()
+			return mount, version, nil
+		}
+		l, waiting := t.lookups[key]
+		if !waiting {
+			l = &mountLookup{done: make(chan struct{})}
+			t.lookups[key] = l
+		}
+		t.mu.Unlock()
+
+		if !waiting {
+			mount, version, err := t.fetch(ctx, client, path, key)
+			t.mu.Lock()
+			delete(t.lookups, key)
+			t.mu.Unlock()
+			l.err = err
+			close(l.done)
+			return mount, version, err
+		}
+
+		select {
+		case <-l.done:
+		case <-ctx.Done():
+			return "", 0, ctx.Err()
+		}
+		if l.err != nil {
+			return "", 0, l.err
+		}
+		// The mount found may not be the one path is on, if mounts are nested, in which case
+		// the loop looks it up again
+	}
+}
+
+// fetch - look up the mount path is on and its version, and record them
+func (t *mountTable) fetch(ctx context.Context, client *vaultApi.Client, path, key string) (string, int, error) {
+	mount, version, err := getMountVersion(ctx, client, path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case mount != "":
+		t.versions[mountKey(mount)] = version
+		log.Debugf("Mount %s of %s is KV API v%d", mount, path, version)
+	case version == 1:
+		// Only Vault versions without the mounts endpoint don't return the mount, so it can't be
+		// told which mount path is on. Assume it's the top-level one.
+		t.versions[key] = version
+		log.Debugf("Vault has no mounts endpoint, assuming mount %s is KV API v1", key)
+	}
+	if mount == "" {
+		mount = key
+	}
+	return sanitisePath(mount), version, nil
+}
+
+// lookup - the longest known mount which path is on, and its version
+func (t *mountTable) lookup(path string) (string, int, bool) {
+	p := mountKey(path)
+	longest := ""
+	for mount := range t.versions {
+		if strings.HasPrefix(p, mount) && len(mount) > len(longest) {
+			longest = mount
+		}
+	}
+	if longest == "" {
+		return "", 0, false
+	}
+	return sanitisePath(longest), t.versions[longest], true
+}
+
+// mountKey - a path as it is keyed in a mountTable
+func mountKey(path string) string {
+	return sanitisePath(path) + "/"
+}
+
+// topLevelKey - the first segment of a path, keyed as a mount
+func topLevelKey(path string) string {
+	return strings.SplitN(sanitisePath(path), "/", 2)[0] + "/"
+}
+
+// ParseMountVersions - parse a comma-separated list of mount paths and their KV API versions,
+// e.g. `secret=2,legacy=1`
+func ParseMountVersions(s string) (map[string]int, error) {
+	versions := make(map[string]int)
+	if strings.TrimSpace(s) == "" {
+		return versions, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		mount := sanitisePath(parts[0])
+		if len(parts) != 2 || mount == "" {
+			return nil, fmt.Errorf("invalid mount version %q, must be of the form mount=version",
+				strings.TrimSpace(item))
+		}
+		version, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || (version != 1 && version != 2) {
+			return nil, fmt.Errorf("invalid KV API version %q for mount %s, must be 1 or 2",
+				strings.TrimSpace(parts[1]), mount)
+		}
+		versions[mount] = version
+	}
+	return versions, nil
+}
+
+// mountNames - the sorted mount paths of versions, for logging
+func mountNames(versions map[string]int) []string {
+	names := make([]string, 0, len(versions))
+	for mount := range versions {
+		names = append(names, mount)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,113 @@
+package vault
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	vaultApi "github.com/hashicorp/vault/api"
+)
+
+// newMountsTestClient - a Vault client of a server which answers mount lookups with handler
+func newMountsTestClient(t *testing.T, handler http.HandlerFunc) *vaultApi.Client {
+	s := httptest.NewServer(handler)
+	t.Cleanup(s.Close)
+	config := vaultApi.DefaultConfig()
+	config.Address = s.URL
+	config.MaxRetries = 0
+	client, err := vaultApi.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestMountTable_Version_OtherMountsDontWait(t *testing.T) {
+	release := make(chan struct{})
+	client := newMountsTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mount := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v1/sys/internal/ui/mounts/"), "/", 2)[0]
+		if mount == "slow" {
+			<-release
+		}
+		_, _ = w.Write([]byte(`{"data": {"path": "` + mount + `/", "options": {"version": "2"}}}`))
+	})
+	mounts := newMountTable()
+
+	done := make(chan error)
+	go func() {
+		_, err := mounts.version(context.Background(), client, "slow/app")
+		done <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := mounts.version(ctx, client, "fast/app"); err != nil {
+		t.Fatalf("expected the lookup of another mount not to wait, got %s", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMountTable_Version_SharesLookupOfMount(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	client := newMountsTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		_, _ = w.Write([]byte(`{"data": {"path": "secret/", "options": {"version": "2"}}}`))
+	})
+	mounts := newMountTable()
+
+	var wg sync.WaitGroup
+	for _, path := range []string{"secret/a", "secret/b", "secret/c/d"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			if version, err := mounts.version(context.Background(), client, path); err != nil || version != 2 {
+				t.Errorf("%s: expected version 2, got %d, %v", path, version, err)
+			}
+		}(path)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected 1 request to the mounts endpoint, got %d", n)
+	}
+}
+
+func TestMountTable_Version_LegacyPerMount(t *testing.T) {
+	client := newMountsTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/sys/internal/ui/mounts/old") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": {"path": "secret/", "options": {"version": "2"}}}`))
+	})
+	mounts := newMountTable()
+
+	for _, tc := range []struct {
+		path    string
+		version int
+	}{
+		{"old/app", 1},
+		{"secret/app", 2},
+		{"old/other", 1},
+	} {
+		version, err := mounts.version(context.Background(), client, tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != tc.version {
+			t.Errorf("%s: expected version %d, got %d", tc.path, tc.version, version)
+		}
+	}
+}
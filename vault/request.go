@@ -0,0 +1,95 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	vaultApi "github.com/hashicorp/vault/api"
+)
+
+// The Logical and Token methods of the Vault client don't take a context, so can't be
+// cancelled. These are equivalents which do, making requests with RawRequestWithContext.
+
+// readWithContext - read path, with the given query parameters if not nil
+func readWithContext(ctx context.Context, client *vaultApi.Client, path string,
+	params url.Values) (*vaultApi.Secret, error) {
+	r := client.NewRequest("GET", "/v1/"+path)
+	if params != nil {
+		r.Params = params
+	}
+	return doRequest(ctx, client, r)
+}
+
+// listWithContext - list the keys at path
+func listWithContext(ctx context.Context, client *vaultApi.Client, path string) (*vaultApi.Secret, error) {
+	r := client.NewRequest("GET", "/v1/"+path)
+	r.Params.Set("list", "true")
+	return doRequest(ctx, client, r)
+}
+
+// writeWithContext - write data to path
+func writeWithContext(ctx context.Context, client *vaultApi.Client, path string,
+	data map[string]interface{}) (*vaultApi.Secret, error) {
+	r := client.NewRequest("PUT", "/v1/"+path)
+	if err := r.SetJSONBody(data); err != nil {
+		return nil, err
+	}
+	return doRequest(ctx, client, r)
+}
+
+// deleteWithContext - delete path
+func deleteWithContext(ctx context.Context, client *vaultApi.Client, path string) (*vaultApi.Secret, error) {
+	return doRequest(ctx, client, client.NewRequest("DELETE", "/v1/"+path))
+}
+
+// lookupSelfWithContext - look up the client's token
+func lookupSelfWithContext(ctx context.Context, client *vaultApi.Client) (*vaultApi.Secret, error) {
+	return doRequest(ctx, client, client.NewRequest("GET", "/v1/auth/token/lookup-self"))
+}
+
+// unwrapWithContext - unwrap a response-wrapped secret, authenticating with the wrapping token
+// if the client has no token of its own
+func unwrapWithContext(ctx context.Context, client *vaultApi.Client, wrappingToken string) (*vaultApi.Secret, error) {
+	r := client.NewRequest("PUT", "/v1/sys/wrapping/unwrap")
+	var data map[string]interface{}
+	switch client.Token() {
+	case "":
+		r.ClientToken = wrappingToken
+	case wrappingToken:
+	default:
+		data = map[string]interface{}{"token": wrappingToken}
+	}
+	if err := r.SetJSONBody(data); err != nil {
+		return nil, err
+	}
+	return doRequest(ctx, client, r)
+}
+
+// doRequest - make a request and parse the secret returned. As with the Logical methods, a
+// 404 is a nil secret rather than an error, unless Vault returned warnings or data with it.
+func doRequest(ctx context.Context, client *vaultApi.Client, r *vaultApi.Request) (*vaultApi.Secret, error) {
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == 404 {
+		secret, parseErr := vaultApi.ParseSecret(resp.Body)
+		switch parseErr {
+		case nil:
+		case io.EOF:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("failed parsing %d response: %s", resp.StatusCode, parseErr)
+		}
+		if secret != nil && (len(secret.Warnings) > 0 || len(secret.Data) > 0) {
+			return secret, nil
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return vaultApi.ParseSecret(resp.Body)
+}
@@ -0,0 +1,34 @@
+package vault
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vaultApi "github.com/hashicorp/vault/api"
+)
+
+func TestDoRequest_MalformedNotFound(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("<html>not found</html>"))
+	}))
+	defer s.Close()
+
+	config := vaultApi.DefaultConfig()
+	config.Address = s.URL
+	client, err := vaultApi.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := readWithContext(context.Background(), client, "secret/app", nil)
+	if err == nil {
+		t.Fatalf("expected an error, got secret %v", secret)
+	}
+	if !strings.Contains(err.Error(), "failed parsing 404 response") {
+		t.Errorf("expected a parse error, got %q", err)
+	}
+}
@@ -0,0 +1,57 @@
+package vault
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy - how requests to Vault are retried after a connection error or 5xx response.
+// The wait before each retry doubles from MinWait up to MaxWait, and is jittered so that many
+// clients failing at once don't all retry at once.
+type RetryPolicy struct {
+	MaxRetries int           // Retries after the first attempt, or negative to keep the client's
+	MinWait    time.Duration // Wait before the first retry
+	MaxWait    time.Duration // Longest wait before any retry
+}
+
+// DefaultRetryPolicy - the retry policy used unless WithRetryPolicy is given
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: -1,
+	MinWait:    500 * time.Millisecond,
+	MaxWait:    10 * time.Second,
+}
+
+// WithRetryPolicy - retry failed requests to Vault with the given policy
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *BaseClient) {
+		if p.MaxRetries >= 0 {
+			c.client.SetMaxRetries(p.MaxRetries)
+		}
+		c.client.SetBackoff(p.backoff)
+	}
+}
+
+// WithRequestTimeout - give up on each request to Vault after d, including its retries
+func WithRequestTimeout(d time.Duration) Option {
+	return func(c *BaseClient) {
+		c.client.SetClientTimeout(d)
+	}
+}
+
+// backoff - the wait before the given retry, counting from 0. Satisfies retryablehttp.Backoff,
+// ignoring the minimum and maximum the client passes in favour of the policy's.
+func (p RetryPolicy) backoff(_, _ time.Duration, attempt int, _ *http.Response) time.Duration {
+	wait := p.MaxWait
+	if attempt < 32 {
+		if w := p.MinWait << uint(attempt); w > 0 && w < p.MaxWait {
+			wait = w
+		}
+	}
+	// Wait at least half as long, so that retries still back off
+	half := wait / 2
+	if half <= 0 {
+		return wait
+	}
+	return half + time.Duration(rand.Int63n(int64(wait-half)+1))
+}
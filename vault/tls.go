@@ -0,0 +1,64 @@
+package vault
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	vaultApi "github.com/hashicorp/vault/api"
+)
+
+// TLSConfig - how connections to Vault are secured. Empty fields keep the settings read from the
+// VAULT_CACERT, VAULT_CLIENT_CERT, VAULT_CLIENT_KEY and VAULT_TLS_SERVER_NAME environment
+// variables, so that certificates needn't be baked into the image.
+type TLSConfig struct {
+	CACert     string // PEM bundle of the CAs to trust, instead of the system's
+	ClientCert string // PEM certificate presented to Vault, e.g. for the cert auth method
+	ClientKey  string
+	ServerName string // Name to verify the server's certificate against, if not its address
+	MinVersion uint16 // e.g. tls.VersionTLS13, or 0 to keep the client's default of TLS 1.2
+}
+
+// WithTLS - secure connections to Vault with the given settings
+func WithTLS(t TLSConfig) Option {
+	return func(c *BaseClient) {
+		c.tls = &t
+	}
+}
+
+// configure - apply the settings to the HTTP client of config
+func (t *TLSConfig) configure(config *vaultApi.Config) error {
+	err := config.ConfigureTLS(&vaultApi.TLSConfig{
+		CACert:        t.CACert,
+		ClientCert:    t.ClientCert,
+		ClientKey:     t.ClientKey,
+		TLSServerName: t.ServerName,
+	})
+	if err != nil {
+		return err
+	}
+	if t.MinVersion != 0 {
+		config.HttpClient.Transport.(*http.Transport).TLSClientConfig.MinVersion = t.MinVersion
+	}
+	return nil
+}
+
+// tlsVersions - the TLS versions which may be given to ParseTLSVersion
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion - parse a TLS version such as "1.2", or return 0 if s is empty
+func ParseTLSVersion(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	version, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("invalid TLS version %q, must be one of 1.0, 1.1, 1.2 or 1.3", s)
+	}
+	return version, nil
+}
@@ -0,0 +1,136 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClientCert - write a self-signed client certificate and its key to dir
+func writeClientCert(t *testing.T, dir string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "talebearer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	writeTestFile(t, certFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeTestFile(t, keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	return certFile, keyFile
+}
+
+func TestNewVaultClient_CertAuth(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeClientCert(t, dir)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/v1/auth/cert/login" {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": "certToken"},
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"id": r.Header.Get("X-Vault-Token")},
+		})
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := filepath.Join(dir, "ca.crt")
+	writeTestFile(t, caFile, string(pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: srv.Certificate().Raw,
+	})))
+
+	defer os.Setenv("VAULT_ADDR", os.Getenv("VAULT_ADDR"))
+	defer os.Setenv("VAULT_TOKEN", os.Getenv("VAULT_TOKEN"))
+	os.Setenv("VAULT_ADDR", srv.URL)
+	os.Unsetenv("VAULT_TOKEN")
+
+	tlsConfig := TLSConfig{
+		CACert:     caFile,
+		ClientCert: certFile,
+		ClientKey:  keyFile,
+		ServerName: "example.com", // Which the httptest certificate is issued for
+	}
+	c, err := NewVaultClient(true, WithAuthMethod("cert", nil), WithTLS(tlsConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := c.(*BaseClient)
+	client.tokenFile = ""
+	if err := client.Authenticate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if token := client.client.Token(); token != "certToken" {
+		t.Errorf("expected token certToken, got %s", token)
+	}
+
+	// The server only allows TLS 1.2
+	tlsConfig.MinVersion = tls.VersionTLS13
+	c, err = NewVaultClient(true, WithAuthMethod("cert", nil), WithTLS(tlsConfig),
+		WithRetryPolicy(RetryPolicy{MaxRetries: 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = c.(*BaseClient)
+	client.tokenFile = ""
+	if err := client.Authenticate(context.Background(), ""); err == nil {
+		t.Error("expected the connection to fail below the minimum TLS version")
+	}
+}
+
+func TestNewVaultClient_TLSMissingKey(t *testing.T) {
+	_, err := NewVaultClient(true, WithTLS(TLSConfig{ClientCert: "client.crt"}))
+	expected := "failed configuring TLS: both client cert and client key must be provided"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	for s, expected := range map[string]uint16{"": 0, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13} {
+		version, err := ParseTLSVersion(s)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", s, err)
+		}
+		if version != expected {
+			t.Errorf("%q: expected %d, got %d", s, expected, version)
+		}
+	}
+	if _, err := ParseTLSVersion("TLS1.2"); err == nil {
+		t.Error("expected an error")
+	}
+}
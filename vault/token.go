@@ -0,0 +1,125 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tokens which were issued elsewhere are used rather than logging in, in this order:
+//
+//   1. VAULT_TOKEN, which the Vault client reads itself
+//   2. The sink file of a Vault Agent, given with WithAgentSink
+//   3. A token helper, given with WithTokenHelper
+//   4. ~/.vault-token, where `vault login` writes the token if there is no token helper
+//
+// If none of them has a token, the client logs in with its auth method.
+
+// WithAgentSink - use the token a Vault Agent writes to the file sink at path, re-reading it
+// whenever the agent changes it
+func WithAgentSink(path string) Option {
+	return func(c *BaseClient) {
+		c.sink = &tokenSink{path: path}
+	}
+}
+
+// WithTokenHelper - use the token given by a token helper, an executable which prints the
+// token when run with the argument `get`, as the Vault CLI's token helpers do
+func WithTokenHelper(path string) Option {
+	return func(c *BaseClient) {
+		c.tokenHelper = path
+	}
+}
+
+// tokenSink - a file which Vault Agent writes a token to
+type tokenSink struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time // Of the file when the token was last read
+	size    int64
+}
+
+// read - the token in the sink, if the file has changed since it was last read, or empty if
+// it hasn't
+func (s *tokenSink) read() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed reading Vault Agent sink: %s", err)
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return "", nil
+	}
+	contents, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed reading Vault Agent sink: %s", err)
+	}
+	token := strings.TrimSpace(string(contents))
+	if token == "" {
+		return "", fmt.Errorf("Vault Agent sink %s is empty", s.path)
+	}
+	s.modTime, s.size = info.ModTime(), info.Size()
+	return token, nil
+}
+
+// existingToken - the first token issued elsewhere, after VAULT_TOKEN, and where it was found,
+// or empty if there is none
+func (c *BaseClient) existingToken() (token string, source string, err error) {
+	if c.sink != nil {
+		token, err = c.sink.read()
+		return token, "Vault Agent sink " + c.sink.path, err
+	}
+
+	if c.tokenHelper != "" {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(c.tokenHelper, "get")
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			return "", "", fmt.Errorf("token helper %s failed: %s: %s", c.tokenHelper, err,
+				strings.TrimSpace(stderr.String()))
+		}
+		if token = strings.TrimSpace(stdout.String()); token != "" {
+			return token, "token helper " + c.tokenHelper, nil
+		}
+	}
+
+	if c.tokenFile == "" {
+		return "", "", nil
+	}
+	contents, err := ioutil.ReadFile(c.tokenFile)
+	if err != nil {
+		return "", "", nil
+	}
+	return strings.TrimSpace(string(contents)), c.tokenFile, nil
+}
+
+// homeTokenFile - the path of ~/.vault-token, or empty if there is no home directory
+func homeTokenFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".vault-token")
+}
+
+// refreshToken - use the token in the Vault Agent sink, if the agent has changed it
+func (c *BaseClient) refreshToken() error {
+	if c.sink == nil {
+		return nil
+	}
+	token, err := c.sink.read()
+	if err != nil || token == "" {
+		return err
+	}
+	c.logger.Debugf("Vault Agent sink %s has changed, using its new token", c.sink.path)
+	c.client.SetToken(token)
+	return nil
+}
@@ -0,0 +1,114 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	vaultApi "github.com/hashicorp/vault/api"
+)
+
+// withTokenFile - read the token from path rather than ~/.vault-token
+func withTokenFile(path string) Option {
+	return func(c *BaseClient) {
+		c.tokenFile = path
+	}
+}
+
+var errTestLogin = errors.New("logged in")
+
+func writeTestFile(t *testing.T, path string, contents string) {
+	if err := ioutil.WriteFile(path, []byte(contents), 0700); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBaseClient_Authenticate_TokenPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	sink := filepath.Join(dir, "sink")
+	writeTestFile(t, sink, "from-sink\n")
+	helper := filepath.Join(dir, "helper")
+	writeTestFile(t, helper, "#!/bin/sh\n[ \"$1\" = get ] && echo from-helper\n")
+	tokenFile := filepath.Join(dir, ".vault-token")
+	writeTestFile(t, tokenFile, "from-file")
+
+	for _, tc := range []struct {
+		opts     []Option
+		expected string
+	}{
+		{[]Option{WithAgentSink(sink), WithTokenHelper(helper)}, "from-sink"},
+		{[]Option{WithTokenHelper(helper)}, "from-helper"},
+		{nil, "from-file"},
+	} {
+		_, client := newFakeVault(t, true, append(tc.opts, withTokenFile(tokenFile))...)
+		if err := client.Authenticate(context.Background(), ""); err != nil {
+			t.Fatal(err)
+		}
+		if token := client.client.Token(); token != tc.expected {
+			t.Errorf("expected token %s, got %s", tc.expected, token)
+		}
+	}
+}
+
+func TestBaseClient_Authenticate_InvalidTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, ".vault-token")
+	writeTestFile(t, tokenFile, "expired")
+	sink := filepath.Join(dir, "sink")
+	writeTestFile(t, sink, "expired")
+
+	// An invalid ~/.vault-token is ignored, so the client logs in instead
+	_, client := newFakeVault(t, true, withTokenFile(tokenFile))
+	client.authHandler = &mockHandler{ReturnError: errTestLogin}
+	client.authHandler.(*mockHandler).On("Auth", client.client, map[string]string{})
+	if err := client.Authenticate(context.Background(), ""); err != errTestLogin {
+		t.Errorf("expected to log in, got %v", err)
+	}
+
+	// But an invalid token from an explicit source is an error
+	_, client = newFakeVault(t, true, WithAgentSink(sink))
+	err := client.Authenticate(context.Background(), "")
+	if err == nil || err == errTestLogin {
+		t.Errorf("expected an invalid token error, got %v", err)
+	}
+}
+
+func TestBaseClient_Read_RereadsAgentSink(t *testing.T) {
+	dir := t.TempDir()
+	sink := filepath.Join(dir, "sink")
+	writeTestFile(t, sink, "first")
+
+	srv, client := newFakeVault(t, true, WithAgentSink(sink))
+
+	if err := client.Authenticate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Read(context.Background(), "secret/app"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The agent renews its token, revoking the old one
+	writeTestFile(t, sink, "second")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(sink, later, later); err != nil {
+		t.Fatal(err)
+	}
+	revoker, err := vaultApi.NewClient(&vaultApi.Config{Address: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoker.SetToken("first")
+	if err := revoker.Auth().Token().RevokeSelf(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Read(context.Background(), "secret/app"); err != nil {
+		t.Fatal(err)
+	}
+	if token := client.client.Token(); token != "second" {
+		t.Errorf("expected token second, got %s", token)
+	}
+}
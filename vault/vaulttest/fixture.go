@@ -0,0 +1,87 @@
+package vaulttest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fixture - the state a Server starts with, read from a YAML or JSON file such as:
+//
+//	mounts:
+//	  secret: 2
+//	  legacy: 1
+//	secrets:
+//	  secret/app:
+//	    - {password: old}   # Versions of a KV v2 secret, oldest first
+//	    - {password: new}
+//	  legacy/db: {user: app}
+//	tokens: [ci-token]
+//	approle: {app-role-id: app-secret-id}
+//	userpass: {ci: hunter2}
+//	policies:
+//	  app: |
+//	    path "secret/data/app" { capabilities = ["read"] }
+//	wrapped:
+//	  wrapping-token:
+//	    creation_path: auth/approle/role/app/secret-id
+//	    data: {secret_id: app-secret-id}
+type Fixture struct {
+	Mounts   map[string]int         `yaml:"mounts"`   // KV API version of each mount, by path
+	Secrets  map[string]interface{} `yaml:"secrets"`  // Fields of each secret, or a list of versions
+	Tokens   []string               `yaml:"tokens"`   // Accepted as well as RootToken
+	AppRoles map[string]string      `yaml:"approle"`  // Secret ID of each role ID, mounted at approle
+	Users    map[string]string      `yaml:"userpass"` // Password of each user, mounted at userpass
+	Policies map[string]string      `yaml:"policies"` // Rules of each ACL policy, by name
+	Wrapped  map[string]Wrapped     `yaml:"wrapped"`  // Response-wrapped secrets, by wrapping token
+}
+
+// Wrapped - a response-wrapped secret, which can be unwrapped once
+type Wrapped struct {
+	CreationPath string                 `yaml:"creation_path"` // The path of the wrapped request
+	Data         map[string]interface{} `yaml:"data"`
+}
+
+// ReadFixture - read a fixture from a YAML or JSON file
+func ReadFixture(path string) (*Fixture, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading fixture: %s", err)
+	}
+	f := &Fixture{}
+	if err := yaml.Unmarshal(contents, f); err != nil {
+		return nil, fmt.Errorf("failed parsing fixture %s: %s", path, err)
+	}
+	return f, nil
+}
+
+// versions - the versions of the secret at path in the fixture, oldest first
+func (f *Fixture) versions(path string) ([]map[string]interface{}, error) {
+	switch v := f.Secrets[path].(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v}, nil
+	case []interface{}:
+		versions := make([]map[string]interface{}, 0, len(v))
+		for _, item := range v {
+			fields, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("version of secret %s is not a map: %v", path, item)
+			}
+			versions = append(versions, fields)
+		}
+		return versions, nil
+	}
+	return nil, fmt.Errorf("secret %s is neither a map nor a list of versions", path)
+}
+
+// secretPaths - the sorted paths of the fixture's secrets
+func (f *Fixture) secretPaths() []string {
+	paths := make([]string, 0, len(f.Secrets))
+	for path := range f.Secrets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
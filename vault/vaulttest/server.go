@@ -0,0 +1,573 @@
+// Package vaulttest - an in-memory fake of the parts of the Vault HTTP API which talebearer uses,
+// for testing clients end to end
+package vaulttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RootToken - a token which every Server accepts
+const RootToken = "root"
+
+// tokenTTL - in seconds, of the tokens the server issues and those in its fixture, but RootToken
+const tokenTTL = 3600
+
+// Server - a fake Vault server, serving KV v1 and v2 mounts, sys/internal/ui/mounts, token
+// lookup, creation, renewal and revocation, response unwrapping, ACL policies and the approle and
+// userpass auth methods. Policies and token uses are stored but aren't enforced; any request with
+// a valid token is allowed, and tokens don't expire.
+type Server struct {
+	*httptest.Server
+	mu       sync.Mutex
+	mounts   map[string]*mount // Keyed by path, with a trailing slash
+	tokens   map[string]*token
+	appRoles map[string]string
+	users    map[string]string
+	policies map[string]string // Rules of each ACL policy, by name
+	wrapped  map[string]Wrapped
+	issued   int      // Tokens issued by logging in or created
+	requests []string // Method and path of each request
+}
+
+// token - a token the server accepts
+type token struct {
+	policies []string
+	ttl      int    // In seconds, or 0 if it doesn't expire
+	uses     int    // 0 if unlimited
+	parent   string // Revoking the parent also revokes the token
+}
+
+// mount - a KV secrets engine
+type mount struct {
+	version int
+	secrets map[string][]*secretVersion // Keyed by path within the mount, oldest version first
+}
+
+type secretVersion struct {
+	data    map[string]interface{}
+	created time.Time
+	deleted time.Time
+}
+
+// NewServer - start a server with the state in f
+func NewServer(f *Fixture) (*Server, error) {
+	s := &Server{
+		mounts:   make(map[string]*mount),
+		tokens:   map[string]*token{RootToken: {policies: []string{"root"}}},
+		appRoles: f.AppRoles,
+		users:    f.Users,
+		policies: make(map[string]string),
+		wrapped:  make(map[string]Wrapped),
+	}
+	for name, rules := range f.Policies {
+		s.policies[name] = rules
+	}
+	for token, wrapped := range f.Wrapped {
+		s.wrapped[token] = wrapped
+	}
+	for path, version := range f.Mounts {
+		if version != 1 && version != 2 {
+			return nil, fmt.Errorf("invalid KV API version %d for mount %s, must be 1 or 2", version, path)
+		}
+		s.mounts[strings.Trim(path, "/")+"/"] = &mount{
+			version: version,
+			secrets: make(map[string][]*secretVersion),
+		}
+	}
+	for _, id := range f.Tokens {
+		s.tokens[id] = &token{policies: []string{"default"}, ttl: tokenTTL}
+	}
+
+	now := time.Now().UTC()
+	for _, path := range f.secretPaths() {
+		m, p := s.mount(path)
+		if m == nil {
+			return nil, fmt.Errorf("secret %s is not on a mount", path)
+		}
+		versions, err := f.versions(path)
+		if err != nil {
+			return nil, err
+		}
+		if m.version == 1 && len(versions) > 1 {
+			return nil, fmt.Errorf("secret %s has versions, but is on a KV v1 mount", path)
+		}
+		for _, data := range versions {
+			m.secrets[p] = append(m.secrets[p], &secretVersion{data: data, created: now})
+		}
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s, nil
+}
+
+// NewTestServer - start a server with the state in the fixture file at path, which is closed
+// when the test finishes
+func NewTestServer(t testing.TB, path string) *Server {
+	f, err := ReadFixture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Requests - the method and path of each request made so far, e.g. "GET secret/data/app"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// mount - the mount path is on, and the rest of path, or nil if it isn't on one
+func (s *Server) mount(path string) (*mount, string) {
+	path = strings.Trim(path, "/") + "/"
+	longest := ""
+	for mountPath := range s.mounts {
+		if strings.HasPrefix(path, mountPath) && len(mountPath) > len(longest) {
+			longest = mountPath
+		}
+	}
+	if longest == "" {
+		return nil, ""
+	}
+	return s.mounts[longest], strings.Trim(strings.TrimPrefix(path, longest), "/")
+}
+
+// mountPath - the path of m, with a trailing slash
+func (s *Server) mountPath(m *mount) string {
+	for path, candidate := range s.mounts {
+		if candidate == m {
+			return path
+		}
+	}
+	return ""
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	method := r.Method
+	if method == "GET" && r.URL.Query().Get("list") == "true" {
+		method = "LIST"
+	}
+	s.requests = append(s.requests, method+" "+path)
+
+	var body map[string]interface{}
+	if r.Method == "PUT" || r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			respondError(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+			return
+		}
+	}
+
+	switch {
+	case path == "auth/approle/login":
+		s.loginAppRole(w, body)
+		return
+	case strings.HasPrefix(path, "auth/userpass/login/"):
+		s.loginUserpass(w, strings.TrimPrefix(path, "auth/userpass/login/"), body)
+		return
+	case path == "sys/wrapping/lookup":
+		token, _ := body["token"].(string)
+		s.lookupWrapping(w, token)
+		return
+	case path == "sys/wrapping/unwrap" && body["token"] == nil:
+		// Authenticated by the wrapping token itself
+		s.unwrap(w, r.Header.Get("X-Vault-Token"))
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if s.tokens[token] == nil {
+		respondError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch {
+	case path == "auth/token/lookup-self":
+		s.lookupSelf(w, token)
+	case path == "auth/token/create":
+		s.createToken(w, token, body)
+	case path == "auth/token/renew-self":
+		s.renewSelf(w, token)
+	case path == "auth/token/revoke-self":
+		s.revoke(token)
+		w.WriteHeader(http.StatusNoContent)
+	case path == "sys/wrapping/unwrap":
+		wrapping, _ := body["token"].(string)
+		s.unwrap(w, wrapping)
+	case strings.HasPrefix(path, "sys/internal/ui/mounts/"):
+		s.mountInfo(w, strings.TrimPrefix(path, "sys/internal/ui/mounts/"))
+	case strings.HasPrefix(path, "sys/policies/acl/"):
+		s.servePolicy(w, method, strings.TrimPrefix(path, "sys/policies/acl/"), body)
+	default:
+		m, p := s.mount(path)
+		switch {
+		case m == nil:
+			respondError(w, http.StatusNotFound, fmt.Sprintf("no handler for route '%s'", path))
+		case m.version == 1:
+			s.serveKVv1(w, m, method, p, body)
+		default:
+			s.serveKVv2(w, r, m, method, p, body)
+		}
+	}
+}
+
+// issueToken - log in, with a new token
+func (s *Server) issueToken(w http.ResponseWriter, policies []string) {
+	s.respondToken(w, s.newToken(&token{policies: policies, ttl: tokenTTL}))
+}
+
+// newToken - add t, returning its ID
+func (s *Server) newToken(t *token) string {
+	s.issued++
+	id := fmt.Sprintf("s.vaulttest%d", s.issued)
+	s.tokens[id] = t
+	return id
+}
+
+// respondToken - respond with the auth of a token
+func (s *Server) respondToken(w http.ResponseWriter, id string) {
+	t := s.tokens[id]
+	respond(w, http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{
+		"client_token":   id,
+		"policies":       t.policies,
+		"lease_duration": t.ttl,
+		"renewable":      t.ttl > 0,
+	}})
+}
+
+// createToken - create a child of parent, with the ttl and num_uses in body
+func (s *Server) createToken(w http.ResponseWriter, parent string, body map[string]interface{}) {
+	t := &token{policies: s.tokens[parent].policies, ttl: tokenTTL, parent: parent}
+	switch ttl := body["ttl"].(type) {
+	case nil:
+	case float64:
+		t.ttl = int(ttl)
+	case string:
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid ttl: "+err.Error())
+			return
+		}
+		t.ttl = int(d.Seconds())
+	}
+	if uses, ok := body["num_uses"].(float64); ok {
+		t.uses = int(uses)
+	}
+	s.respondToken(w, s.newToken(t))
+}
+
+// renewSelf - renew a token for its TTL again, if it expires
+func (s *Server) renewSelf(w http.ResponseWriter, id string) {
+	if s.tokens[id].ttl == 0 {
+		respondError(w, http.StatusBadRequest, "lease is not renewable")
+		return
+	}
+	s.respondToken(w, id)
+}
+
+// revoke - revoke a token and its children
+func (s *Server) revoke(id string) {
+	delete(s.tokens, id)
+	for child, t := range s.tokens {
+		if t.parent == id {
+			s.revoke(child)
+		}
+	}
+}
+
+func (s *Server) loginAppRole(w http.ResponseWriter, body map[string]interface{}) {
+	roleID, _ := body["role_id"].(string)
+	secretID, _ := body["secret_id"].(string)
+	expected, ok := s.appRoles[roleID]
+	if !ok || expected != secretID {
+		respondError(w, http.StatusBadRequest, "invalid role or secret ID")
+		return
+	}
+	s.issueToken(w, []string{"default"})
+}
+
+func (s *Server) loginUserpass(w http.ResponseWriter, username string, body map[string]interface{}) {
+	password, _ := body["password"].(string)
+	expected, ok := s.users[username]
+	if !ok || expected != password {
+		respondError(w, http.StatusBadRequest, "invalid username or password")
+		return
+	}
+	s.issueToken(w, []string{"default"})
+}
+
+func (s *Server) lookupSelf(w http.ResponseWriter, id string) {
+	t := s.tokens[id]
+	respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+		"id":        id,
+		"policies":  t.policies,
+		"ttl":       t.ttl,
+		"renewable": t.ttl > 0,
+		"num_uses":  t.uses,
+	}})
+}
+
+// lookupWrapping - the creation path of a wrapping token, which needs no other token
+func (s *Server) lookupWrapping(w http.ResponseWriter, token string) {
+	wrapped, ok := s.wrapped[token]
+	if !ok {
+		respondError(w, http.StatusBadRequest, "wrapping token is not valid or does not exist")
+		return
+	}
+	respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+		"creation_path": wrapped.CreationPath,
+		"creation_ttl":  300,
+	}})
+}
+
+// unwrap - the secret wrapped by a token, which is then no longer valid
+func (s *Server) unwrap(w http.ResponseWriter, token string) {
+	wrapped, ok := s.wrapped[token]
+	if !ok {
+		respondError(w, http.StatusBadRequest, "wrapping token is not valid or does not exist")
+		return
+	}
+	delete(s.wrapped, token)
+	respond(w, http.StatusOK, map[string]interface{}{"data": wrapped.Data})
+}
+
+// mountInfo - the preflight request made by the Vault CLI and talebearer to find a path's mount
+func (s *Server) mountInfo(w http.ResponseWriter, path string) {
+	m, _ := s.mount(path)
+	if m == nil {
+		respondError(w, http.StatusForbidden, fmt.Sprintf("preflight capability check returned 403, "+
+			"please ensure client's policies grant access to path \"%s/\"", strings.Trim(path, "/")))
+		return
+	}
+	respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+		"path":    s.mountPath(m),
+		"type":    "kv",
+		"options": map[string]interface{}{"version": strconv.Itoa(m.version)},
+	}})
+}
+
+// servePolicy - read, write or delete the ACL policy called name
+func (s *Server) servePolicy(w http.ResponseWriter, method string, name string,
+	body map[string]interface{}) {
+	switch method {
+	case "GET":
+		rules, ok := s.policies[name]
+		if !ok {
+			respondError(w, http.StatusNotFound)
+			return
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"name":   name,
+			"policy": rules,
+		}})
+	case "PUT", "POST":
+		rules, ok := body["policy"].(string)
+		if !ok || rules == "" {
+			respondError(w, http.StatusBadRequest, "'policy' parameter not supplied or empty")
+			return
+		}
+		s.policies[name] = rules
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(s.policies, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		respondError(w, http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveKVv1(w http.ResponseWriter, m *mount, method string, path string,
+	body map[string]interface{}) {
+	switch method {
+	case "LIST":
+		s.list(w, m, path)
+	case "GET":
+		versions := m.secrets[path]
+		if len(versions) == 0 {
+			respondError(w, http.StatusNotFound)
+			return
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"data": versions[0].data})
+	case "PUT", "POST":
+		m.secrets[path] = []*secretVersion{{data: body, created: time.Now().UTC()}}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(m.secrets, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		respondError(w, http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveKVv2(w http.ResponseWriter, r *http.Request, m *mount, method string,
+	path string, body map[string]interface{}) {
+	switch {
+	case strings.HasPrefix(path+"/", "data/"):
+		path = strings.TrimPrefix(strings.TrimPrefix(path, "data"), "/")
+		s.serveKVv2Data(w, r, m, method, path, body)
+	case strings.HasPrefix(path+"/", "metadata/"):
+		path = strings.TrimPrefix(strings.TrimPrefix(path, "metadata"), "/")
+		switch method {
+		case "LIST":
+			s.list(w, m, path)
+		case "GET":
+			s.metadata(w, m, path)
+		case "DELETE":
+			delete(m.secrets, path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			respondError(w, http.StatusMethodNotAllowed)
+		}
+	default:
+		respondError(w, http.StatusNotFound, fmt.Sprintf("no handler for route '%s%s'", s.mountPath(m), path))
+	}
+}
+
+func (s *Server) serveKVv2Data(w http.ResponseWriter, r *http.Request, m *mount, method string,
+	path string, body map[string]interface{}) {
+	versions := m.secrets[path]
+	switch method {
+	case "GET":
+		n := len(versions)
+		if v := r.URL.Query().Get("version"); v != "" && v != "0" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil {
+				respondError(w, http.StatusBadRequest, "invalid version "+v)
+				return
+			}
+		}
+		if n < 1 || n > len(versions) || !versions[n-1].deleted.IsZero() {
+			respondError(w, http.StatusNotFound)
+			return
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"data":     versions[n-1].data,
+			"metadata": versionMetadata(n, versions[n-1]),
+		}})
+	case "PUT", "POST":
+		data, ok := body["data"].(map[string]interface{})
+		if !ok {
+			respondError(w, http.StatusBadRequest, "no data provided")
+			return
+		}
+		if options, ok := body["options"].(map[string]interface{}); ok && options["cas"] != nil {
+			cas, _ := options["cas"].(float64)
+			if int(cas) != len(versions) {
+				respondError(w, http.StatusBadRequest,
+					"check-and-set parameter did not match the current version")
+				return
+			}
+		}
+		version := &secretVersion{data: data, created: time.Now().UTC()}
+		m.secrets[path] = append(versions, version)
+		respond(w, http.StatusOK, map[string]interface{}{
+			"data": versionMetadata(len(versions)+1, version),
+		})
+	case "DELETE":
+		if len(versions) > 0 {
+			versions[len(versions)-1].deleted = time.Now().UTC()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		respondError(w, http.StatusMethodNotAllowed)
+	}
+}
+
+// metadata - the metadata of every version of a KV v2 secret
+func (s *Server) metadata(w http.ResponseWriter, m *mount, path string) {
+	versions := m.secrets[path]
+	if len(versions) == 0 {
+		respondError(w, http.StatusNotFound)
+		return
+	}
+	all := make(map[string]interface{}, len(versions))
+	for i, v := range versions {
+		metadata := versionMetadata(i+1, v)
+		delete(metadata, "version")
+		all[strconv.Itoa(i+1)] = metadata
+	}
+	respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+		"current_version": len(versions),
+		"oldest_version":  1,
+		"created_time":    formatTime(versions[0].created),
+		"updated_time":    formatTime(versions[len(versions)-1].created),
+		"versions":        all,
+	}})
+}
+
+func versionMetadata(n int, v *secretVersion) map[string]interface{} {
+	return map[string]interface{}{
+		"version":       n,
+		"created_time":  formatTime(v.created),
+		"deletion_time": formatTime(v.deleted),
+		"destroyed":     false,
+	}
+}
+
+// list - the secrets and directories directly under path, as Vault lists them
+func (s *Server) list(w http.ResponseWriter, m *mount, path string) {
+	prefix := ""
+	if path != "" {
+		prefix = path + "/"
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	for p := range m.secrets {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(p, prefix)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		respondError(w, http.StatusNotFound)
+		return
+	}
+	sort.Strings(keys)
+	respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func respond(w http.ResponseWriter, status int, body map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// respondError - respond with errors, as Vault does. A 404 usually has none.
+func respondError(w http.ResponseWriter, status int, errors ...string) {
+	if errors == nil {
+		errors = []string{}
+	}
+	respond(w, status, map[string]interface{}{"errors": errors})
+}
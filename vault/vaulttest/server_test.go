@@ -0,0 +1,49 @@
+package vaulttest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewServer_InvalidFixture(t *testing.T) {
+	for expected, f := range map[string]*Fixture{
+		"invalid KV API version 3 for mount secret": {Mounts: map[string]int{"secret": 3}},
+		"secret other/app is not on a mount": {
+			Mounts:  map[string]int{"secret": 2},
+			Secrets: map[string]interface{}{"other/app": map[string]interface{}{"a": "b"}},
+		},
+		"secret legacy/app has versions, but is on a KV v1 mount": {
+			Mounts: map[string]int{"legacy": 1},
+			Secrets: map[string]interface{}{"legacy/app": []interface{}{
+				map[string]interface{}{"a": "b"}, map[string]interface{}{"a": "c"},
+			}},
+		},
+	} {
+		s, err := NewServer(f)
+		if err == nil {
+			s.Close()
+			t.Errorf("expected error %q", expected)
+			continue
+		}
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error %q, got %q", expected, err)
+		}
+	}
+}
+
+func TestReadFixture(t *testing.T) {
+	f, err := ReadFixture("../testdata/vault.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := f.versions("secret/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[1]["password"] != "second" {
+		t.Errorf("unexpected versions of secret/app: %v", versions)
+	}
+	if _, err := ReadFixture("missing.yaml"); err == nil {
+		t.Error("expected an error reading a missing fixture")
+	}
+}
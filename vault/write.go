@@ -1,6 +1,9 @@
 package vault
 
 import (
+	"context"
+	"sort"
+
 	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 )
@@ -8,6 +11,7 @@ import (
 type writeClient struct {
 	logger *log.Entry
 	client *vaultApi.Client
+	mounts *mountTable // Shared with the BaseClient
 }
 
 // Used by sysAuthHandler
@@ -47,23 +51,37 @@ func (c *writeClient) DeletePolicy(name string) error {
 }
 
 // Used by genericHandler
-func (c *writeClient) Write(path string, data map[string]interface{}) (*vaultApi.Secret, error) {
-	p, err := pathToSecret(c.client, path)
+func (c *writeClient) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	p, err := pathToSecret(ctx, c.client, c.mounts, path)
 	if err != nil {
 		return nil, err
 	}
 	c.logger.WithFields(log.Fields{
 		"action": "Write",
 		"path":   p,
-		"data":   data,
+		"fields": fieldNames(data),
 	}).Debug("Calling Vault API")
-	return c.client.Logical().Write(p, data)
+	return writeWithContext(ctx, c.client, p, data)
+}
+
+// fieldNames - the names of the fields of a secret written with data, which is wrapped in "data"
+// for KV v2, so that writes are logged without the secret's values
+func fieldNames(data map[string]interface{}) []string {
+	if fields, ok := data["data"].(map[string]interface{}); ok {
+		data = fields
+	}
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-func (c *writeClient) Delete(path string) (*vaultApi.Secret, error) {
+func (c *writeClient) Delete(ctx context.Context, path string) (*vaultApi.Secret, error) {
 	c.logger.WithFields(log.Fields{
 		"action": "Delete",
 		"path":   path,
 	}).Debug("Calling Vault API")
-	return c.client.Logical().Delete(path)
+	return deleteWithContext(ctx, c.client, path)
 }